@@ -0,0 +1,81 @@
+//    auth.go
+//    ~~~~~~~~~
+//    This module implements the authorization verification functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/golang-jwt/jwt"
+	"strings"
+)
+
+// An AuthVerifier function checks the Authorization header of a publish
+// request received by an EPCP endpoint. It is the counterpart of the auth
+// settings of PubControlClient.
+type AuthVerifier func(authHeader string) bool
+
+// Get a verifier accepting the header produced by SetAuthBasic with the
+// specified credentials.
+func VerifyBasic(user, pass string) AuthVerifier {
+	expected := "Basic " + base64.StdEncoding.EncodeToString(
+		[]byte(user+":"+pass))
+	return verifyExact(expected)
+}
+
+// Get a verifier accepting the header produced by SetAuthBearer with the
+// specified token.
+func VerifyBearer(token string) AuthVerifier {
+	return verifyExact("Bearer " + token)
+}
+
+// Get a verifier accepting the header produced by SetAuthJwt: an
+// unexpired token signed with HS256 using the specified key. If the issuer
+// is not empty then the iss claim must match it.
+func VerifyJwt(iss string, key []byte) AuthVerifier {
+	return func(authHeader string) bool {
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			return false
+		}
+		token, err := jwt.Parse(strings.TrimPrefix(authHeader, "Bearer "),
+			func(token *jwt.Token) (interface{}, error) {
+				if token.Method != jwt.SigningMethodHS256 {
+					return nil, fmt.Errorf("Unexpected signing method: %v",
+						token.Header["alg"])
+				}
+				return key, nil
+			})
+		if err != nil || !token.Valid {
+			return false
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		return ok && (iss == "" || claims.VerifyIssuer(iss, true))
+	}
+}
+
+// Get a verifier accepting a header accepted by any of the specified
+// verifiers.
+func VerifyAny(verifiers ...AuthVerifier) AuthVerifier {
+	return func(authHeader string) bool {
+		for _, verifier := range verifiers {
+			if verifier(authHeader) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// An internal function that returns a verifier comparing the header with
+// the expected value in constant time.
+func verifyExact(expected string) AuthVerifier {
+	return func(authHeader string) bool {
+		return subtle.ConstantTimeCompare([]byte(authHeader),
+			[]byte(expected)) == 1
+	}
+}
@@ -0,0 +1,55 @@
+//    auth_test.go
+//    ~~~~~~~~~
+//    This module implements the authorization verification tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestVerifyBasicAndBearer(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.SetAuthBasic("user", "pass")
+	header, _ := pcc.generateAuthHeader()
+	assert.True(t, VerifyBasic("user", "pass")(header))
+	assert.False(t, VerifyBasic("user", "other")(header))
+	pcc = NewPubControlClient("uri")
+	pcc.SetAuthBearer("token")
+	header, _ = pcc.generateAuthHeader()
+	assert.True(t, VerifyBearer("token")(header))
+	assert.False(t, VerifyBearer("token2")(header))
+	assert.False(t, VerifyBearer("token")(""))
+}
+
+func TestVerifyJwt(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "realm"}, []byte("key"))
+	header, _ := pcc.generateAuthHeader()
+	assert.True(t, VerifyJwt("realm", []byte("key"))(header))
+	assert.True(t, VerifyJwt("", []byte("key"))(header))
+	assert.False(t, VerifyJwt("other", []byte("key"))(header))
+	assert.False(t, VerifyJwt("realm", []byte("wrong"))(header))
+	assert.False(t, VerifyJwt("realm", []byte("key"))("Basic abc"))
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "realm",
+		"exp": time.Now().Add(-time.Minute).Unix()}, []byte("key"))
+	header, _ = pcc.generateAuthHeader()
+	assert.False(t, VerifyJwt("realm", []byte("key"))(header))
+	token := jwt.NewWithClaims(jwt.SigningMethodNone,
+		jwt.MapClaims{"iss": "realm"})
+	unsigned, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.False(t, VerifyJwt("realm", []byte("key"))("Bearer "+unsigned))
+}
+
+func TestVerifyAny(t *testing.T) {
+	verifier := VerifyAny(VerifyBearer("a"), VerifyBearer("b"))
+	assert.True(t, verifier("Bearer b"))
+	assert.False(t, verifier("Bearer c"))
+	assert.False(t, VerifyAny()("Bearer a"))
+}
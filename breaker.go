@@ -0,0 +1,232 @@
+//    breaker.go
+//    ~~~~~~~~~
+//    This module implements the CircuitBreaker functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The state of a CircuitBreaker instance.
+type CircuitState int
+
+const (
+	// Requests are passed through and failures are counted.
+	CircuitClosed CircuitState = iota
+
+	// Requests fail immediately without reaching the endpoint.
+	CircuitOpen
+
+	// A limited number of probe requests are passed through to determine
+	// whether the endpoint has recovered.
+	CircuitHalfOpen
+)
+
+// This function returns the name of the circuit state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// The CircuitBreakerConfig struct contains the settings used by a
+// CircuitBreaker instance. Zero values are replaced with defaults.
+type CircuitBreakerConfig struct {
+
+	// The number of consecutive failures after which the circuit opens.
+	// Defaults to 5.
+	FailureThreshold int
+
+	// How long the circuit stays open before probes are allowed through.
+	// Defaults to 30 seconds.
+	OpenDuration time.Duration
+
+	// The number of probe requests allowed while half-open. The circuit
+	// closes once this many probes have succeeded. Defaults to 1.
+	HalfOpenProbes int
+
+	// An optional function called whenever the circuit changes state. It
+	// is called synchronously from the publishing goroutine and must not
+	// block.
+	OnStateChange func(from, to CircuitState)
+}
+
+// The CircuitBreaker struct tracks the health of a single publishing
+// endpoint. Once the configured number of consecutive failures is reached
+// the circuit opens and publishes fail immediately with a CircuitOpenError
+// instead of waiting for the endpoint to time out. After the open duration
+// has elapsed a limited number of probes are let through, and the circuit
+// either closes again or reopens depending on their outcome.
+type CircuitBreaker struct {
+	lock      sync.Mutex
+	config    CircuitBreakerConfig
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+	now       func() time.Time
+}
+
+// Initialize this struct with the specified configuration.
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = 30 * time.Second
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+	cb := new(CircuitBreaker)
+	cb.config = config
+	cb.state = CircuitClosed
+	cb.now = time.Now
+	return cb
+}
+
+// Get the current state of the circuit. An open circuit whose open
+// duration has elapsed is reported as half-open.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.lock.Lock()
+	from, to := cb.refresh()
+	state := cb.state
+	cb.lock.Unlock()
+	cb.notify(from, to)
+	return state
+}
+
+// Reset the circuit to the closed state and clear all failure counts.
+func (cb *CircuitBreaker) Reset() {
+	cb.lock.Lock()
+	from, to := cb.transition(CircuitClosed)
+	cb.lock.Unlock()
+	cb.notify(from, to)
+}
+
+// An internal method called before a request is made. An error is returned
+// if the request should not be made because the circuit is open or all
+// half-open probes are already in flight.
+func (cb *CircuitBreaker) allow() error {
+	cb.lock.Lock()
+	from, to := cb.refresh()
+	var err error
+	switch cb.state {
+	case CircuitOpen:
+		err = &CircuitOpenError{err: "Circuit breaker is open, retry after " +
+			cb.openedAt.Add(cb.config.OpenDuration).Sub(
+				cb.now()).Round(time.Millisecond).String()}
+	case CircuitHalfOpen:
+		if cb.probes >= cb.config.HalfOpenProbes {
+			err = &CircuitOpenError{err: "Circuit breaker is half-open with " +
+				strconv.Itoa(cb.probes) + " probe(s) in flight"}
+		} else {
+			cb.probes++
+		}
+	}
+	cb.lock.Unlock()
+	cb.notify(from, to)
+	return err
+}
+
+// An internal method called with the result of a request that was allowed
+// through by the allow method.
+func (cb *CircuitBreaker) record(err error) {
+	cb.lock.Lock()
+	from, to := CircuitClosed, CircuitClosed
+	switch cb.state {
+	case CircuitClosed:
+		if err == nil {
+			cb.failures = 0
+		} else {
+			cb.failures++
+			if cb.failures >= cb.config.FailureThreshold {
+				from, to = cb.transition(CircuitOpen)
+			}
+		}
+	case CircuitHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		if err != nil {
+			from, to = cb.transition(CircuitOpen)
+		} else {
+			cb.successes++
+			if cb.successes >= cb.config.HalfOpenProbes {
+				from, to = cb.transition(CircuitClosed)
+			}
+		}
+	}
+	cb.lock.Unlock()
+	cb.notify(from, to)
+}
+
+// An internal method called instead of the record method when a request
+// that was allowed through by the allow method was never made.
+func (cb *CircuitBreaker) cancel() {
+	cb.lock.Lock()
+	if cb.state == CircuitHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+	cb.lock.Unlock()
+}
+
+// An internal method that moves an open circuit to half-open once the open
+// duration has elapsed. The lock must be held by the caller.
+func (cb *CircuitBreaker) refresh() (CircuitState, CircuitState) {
+	if cb.state == CircuitOpen &&
+		!cb.now().Before(cb.openedAt.Add(cb.config.OpenDuration)) {
+		return cb.transition(CircuitHalfOpen)
+	}
+	return cb.state, cb.state
+}
+
+// An internal method that changes the state of the circuit and resets the
+// counters associated with the new state. The lock must be held by the
+// caller. The previous and new states are returned so that the state
+// change can be reported after the lock is released.
+func (cb *CircuitBreaker) transition(state CircuitState) (CircuitState,
+	CircuitState) {
+	from := cb.state
+	cb.state = state
+	cb.failures = 0
+	cb.probes = 0
+	cb.successes = 0
+	if state == CircuitOpen {
+		cb.openedAt = cb.now()
+	}
+	return from, state
+}
+
+// An internal method that calls the state change function if the state
+// has changed.
+func (cb *CircuitBreaker) notify(from, to CircuitState) {
+	if from != to && cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(from, to)
+	}
+}
+
+// An error struct used to represent a publish that was rejected because
+// the circuit breaker of the client is open.
+type CircuitOpenError struct {
+	err string
+}
+
+// This function returns the message associated with the CircuitOpenError
+// error struct.
+func (e CircuitOpenError) Error() string {
+	return e.err
+}
@@ -0,0 +1,217 @@
+//    breaker_test.go
+//    ~~~~~~~~~
+//    This module implements the CircuitBreaker tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(config CircuitBreakerConfig) (*CircuitBreaker,
+	*testClock) {
+	clock := &testClock{now: time.Unix(1428374723, 0)}
+	cb := NewCircuitBreaker(config)
+	cb.now = clock.Now
+	return cb, clock
+}
+
+func TestCbDefaults(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{})
+	assert.Equal(t, cb.config.FailureThreshold, 5)
+	assert.Equal(t, cb.config.OpenDuration, 30*time.Second)
+	assert.Equal(t, cb.config.HalfOpenProbes, 1)
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
+
+func TestCbOpensAfterThreshold(t *testing.T) {
+	cb, _ := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 2})
+	assert.Nil(t, cb.allow())
+	cb.record(errors.New("failure"))
+	assert.Equal(t, cb.State(), CircuitClosed)
+	assert.Nil(t, cb.allow())
+	cb.record(errors.New("failure"))
+	assert.Equal(t, cb.State(), CircuitOpen)
+	err := cb.allow()
+	assert.NotNil(t, err)
+	_, ok := err.(*CircuitOpenError)
+	assert.True(t, ok)
+}
+
+func TestCbSuccessResetsFailures(t *testing.T) {
+	cb, _ := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 2})
+	cb.record(errors.New("failure"))
+	cb.record(nil)
+	cb.record(errors.New("failure"))
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
+
+func TestCbHalfOpenProbes(t *testing.T) {
+	cb, clock := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1,
+		OpenDuration: time.Second, HalfOpenProbes: 2})
+	cb.record(errors.New("failure"))
+	assert.Equal(t, cb.State(), CircuitOpen)
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, cb.State(), CircuitHalfOpen)
+	assert.Nil(t, cb.allow())
+	assert.Nil(t, cb.allow())
+	assert.NotNil(t, cb.allow())
+	cb.record(nil)
+	assert.Equal(t, cb.State(), CircuitHalfOpen)
+	cb.record(nil)
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
+
+func TestCbHalfOpenFailureReopens(t *testing.T) {
+	cb, clock := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1,
+		OpenDuration: time.Second})
+	cb.record(errors.New("failure"))
+	clock.now = clock.now.Add(time.Second)
+	assert.Nil(t, cb.allow())
+	cb.record(errors.New("failure"))
+	assert.Equal(t, cb.State(), CircuitOpen)
+	assert.NotNil(t, cb.allow())
+}
+
+func TestCbStateChangeEvents(t *testing.T) {
+	changes := make([]CircuitState, 0)
+	cb, clock := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1,
+		OpenDuration: time.Second,
+		OnStateChange: func(from, to CircuitState) {
+			changes = append(changes, from, to)
+		}})
+	cb.record(errors.New("failure"))
+	clock.now = clock.now.Add(time.Second)
+	assert.Nil(t, cb.allow())
+	cb.record(nil)
+	cb.Reset()
+	assert.Equal(t, changes, []CircuitState{CircuitClosed, CircuitOpen,
+		CircuitOpen, CircuitHalfOpen, CircuitHalfOpen, CircuitClosed})
+}
+
+func TestPccPublishCircuitBreaker(t *testing.T) {
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	item := NewItem(formats, "", "")
+	calls := 0
+	pcc := NewPubControlClient("uri")
+	pcc.publish = func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		calls++
+		return errors.New("failure")
+	}
+	cb, _ := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 2})
+	pcc.SetCircuitBreaker(cb)
+	assert.Equal(t, pcc.CircuitBreaker(), cb)
+	assert.NotNil(t, pcc.Publish("chan", item))
+	assert.NotNil(t, pcc.Publish("chan", item))
+	err := pcc.Publish("chan", item)
+	_, ok := err.(*CircuitOpenError)
+	assert.True(t, ok)
+	assert.Equal(t, calls, 2)
+}
+
+func TestPccPublishCircuitBreakerItemError(t *testing.T) {
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	formats = append(formats, fmt1b)
+	item := NewItem(formats, "", "")
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	cb, _ := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1})
+	pcc.SetCircuitBreaker(cb)
+	assert.NotNil(t, pcc.Publish("chan", item))
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
+
+func TestPccPublishCircuitBreakerDeadline(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.publish = func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1})
+	pcc.SetCircuitBreaker(cb)
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, pcc.PublishContext(ctx, "chan", newPolicyTestItem()),
+		context.DeadlineExceeded)
+	assert.Equal(t, cb.State(), CircuitOpen)
+}
+
+func TestPcPublishCircuitBreakerCancelRemaining(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1})
+	done := make(chan struct{})
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, publishSuccess)
+	pc.clients[0].SetCircuitBreaker(cb)
+	pc.clients[0].AddInterceptor(func(ctx context.Context, channel string,
+		item *Item, next PublishFunc) error {
+		defer close(done)
+		return next(ctx, channel, item)
+	})
+	pc.SetPublishPolicy(PolicyAny().WithCancelRemaining())
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	<-done
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
+
+func TestPccPublishCircuitBreakerHalfOpenPanic(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.publish = func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		panic("Intentional panic for tests")
+	}
+	cb, clock := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1,
+		OpenDuration: time.Second})
+	pcc.SetCircuitBreaker(cb)
+	cb.record(errors.New("failure"))
+	clock.now = clock.now.Add(time.Second)
+	assert.Panics(t, func() {
+		pcc.Publish("chan", newPolicyTestItem())
+	})
+	assert.Equal(t, cb.State(), CircuitOpen)
+	clock.now = clock.now.Add(time.Second)
+	pcc.pubCall = pubCallTestMethod
+	pcc.publish = publish
+	assert.Nil(t, pcc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
+
+func TestPccPublishCircuitBreakerHalfOpenItemError(t *testing.T) {
+	invalid := NewItem([]Formatter{fmt1a, fmt1b}, "", "")
+	valid := NewItem([]Formatter{fmt1a}, "", "")
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	cb, clock := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1,
+		OpenDuration: time.Second})
+	pcc.SetCircuitBreaker(cb)
+	cb.record(errors.New("failure"))
+	clock.now = clock.now.Add(time.Second)
+	assert.Equal(t, cb.State(), CircuitHalfOpen)
+	_, ok := pcc.Publish("chan", invalid).(*ItemFormatError)
+	assert.True(t, ok)
+	assert.Equal(t, cb.State(), CircuitHalfOpen)
+	assert.Nil(t, pcc.Publish("chan", valid))
+	assert.Equal(t, cb.State(), CircuitClosed)
+}
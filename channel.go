@@ -0,0 +1,157 @@
+//    channel.go
+//    ~~~~~~~~~
+//    This module implements the ChannelValidator functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The ChannelConfig struct contains the settings used by a
+// ChannelValidator instance. Channels are normalized first, by applying
+// the Normalize function and then adding the prefix, and the result is
+// validated. Channels that are empty before the prefix is added, that are
+// not valid UTF-8 or that contain control characters are always rejected,
+// as they are by PubControl even without a ChannelValidator.
+type ChannelConfig struct {
+
+	// An optional function applied to every channel, for example
+	// strings.ToLower or strings.TrimSpace.
+	Normalize func(channel string) string
+
+	// An optional prefix added to every channel that does not already
+	// start with it, for example the name of a tenant followed by a
+	// separator.
+	Prefix string
+
+	// The maximum length of a channel in bytes, including the prefix. Zero
+	// disables the limit.
+	MaxLength int
+
+	// An optional function reporting whether a character may appear in a
+	// channel. See ChannelChars.
+	Allowed func(r rune) bool
+}
+
+// Get a function for the Allowed setting of ChannelConfig that allows
+// ASCII letters and digits as well as the specified extra characters.
+func ChannelChars(extra string) func(r rune) bool {
+	return func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+			(r >= '0' && r <= '9') || strings.ContainsRune(extra, r)
+	}
+}
+
+// The ChannelValidator struct normalizes and validates channel names
+// before items are published to them, so that invalid channels fail
+// without a round trip to the endpoint. PubControl instances always reject
+// empty and malformed channels; add the Interceptor of a validator to a
+// PubControl or PubControlClient instance to apply custom rules to every
+// publish.
+type ChannelValidator struct {
+	config ChannelConfig
+}
+
+// Initialize this struct with the specified configuration.
+func NewChannelValidator(config ChannelConfig) *ChannelValidator {
+	v := new(ChannelValidator)
+	v.config = config
+	return v
+}
+
+// Normalize the specified channel and validate the result. The normalized
+// channel is returned, or a ChannelError if it is invalid.
+func (v *ChannelValidator) Validate(channel string) (string, error) {
+	normalized := channel
+	if v.config.Normalize != nil {
+		normalized = v.config.Normalize(normalized)
+	}
+	if normalized == "" {
+		return "", &ChannelError{err: "Channel must not be empty",
+			channel: channel}
+	}
+	if !strings.HasPrefix(normalized, v.config.Prefix) {
+		normalized = v.config.Prefix + normalized
+	}
+	if v.config.MaxLength > 0 && len(normalized) > v.config.MaxLength {
+		return "", &ChannelError{err: "Channel " + strconv.Quote(normalized) +
+			" is longer than " + strconv.Itoa(v.config.MaxLength) + " bytes",
+			channel: channel}
+	}
+	if err := checkChannelChars(normalized, channel,
+		v.config.Allowed); err != nil {
+		return "", err
+	}
+	return normalized, nil
+}
+
+// An internal function that rejects channels that are empty, that are not
+// valid UTF-8 or that contain control characters, which are never valid.
+func validateChannel(channel string) error {
+	if channel == "" {
+		return &ChannelError{err: "Channel must not be empty",
+			channel: channel}
+	}
+	return checkChannelChars(channel, channel, nil)
+}
+
+// An internal function that returns a ChannelError for the specified
+// original channel if the normalized channel is not valid UTF-8 or
+// contains a control character or a character that is not allowed by the
+// optional allowed function.
+func checkChannelChars(normalized, channel string,
+	allowed func(r rune) bool) error {
+	if !utf8.ValidString(normalized) {
+		return &ChannelError{err: "Channel " + strconv.Quote(normalized) +
+			" is not valid UTF-8", channel: channel}
+	}
+	for _, r := range normalized {
+		if unicode.IsControl(r) || (allowed != nil && !allowed(r)) {
+			return &ChannelError{err: "Channel " +
+				strconv.Quote(normalized) + " contains the invalid character " +
+				strconv.QuoteRune(r), channel: channel}
+		}
+	}
+	return nil
+}
+
+// Get an Interceptor that publishes every item to its normalized channel
+// and fails the publish with a ChannelError if a channel is invalid. In a
+// batch, an invalid channel fails the whole batch. Add the interceptor
+// after any other interceptors that change channels so that it validates
+// the channels that are published to.
+func (v *ChannelValidator) Interceptor() Interceptor {
+	return func(ctx context.Context, channel string, item *Item,
+		next PublishFunc) error {
+		normalized, err := v.Validate(channel)
+		if err != nil {
+			return err
+		}
+		return next(ctx, normalized, item)
+	}
+}
+
+// An error struct used to represent an invalid channel.
+type ChannelError struct {
+	err     string
+	channel string
+}
+
+// Get the channel that was rejected, as it was passed to the validator.
+func (e ChannelError) Channel() string {
+	return e.channel
+}
+
+// This function returns the message associated with the ChannelError
+// error struct.
+func (e ChannelError) Error() string {
+	return e.err
+}
@@ -0,0 +1,98 @@
+//    channel_test.go
+//    ~~~~~~~~~
+//    This module implements the ChannelValidator tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestChannelValidatorDefaults(t *testing.T) {
+	v := NewChannelValidator(ChannelConfig{})
+	channel, err := v.Validate("any channel/ünïcode")
+	assert.Nil(t, err)
+	assert.Equal(t, channel, "any channel/ünïcode")
+	for _, channel := range []string{"", "a\nb", "a\x00", "\xff"} {
+		_, err := v.Validate(channel)
+		channelErr, ok := err.(*ChannelError)
+		assert.True(t, ok, channel)
+		assert.Equal(t, channelErr.Channel(), channel)
+	}
+}
+
+func TestChannelValidatorConfig(t *testing.T) {
+	v := NewChannelValidator(ChannelConfig{
+		Normalize: func(channel string) string {
+			return strings.ToLower(strings.TrimSpace(channel))
+		},
+		Prefix:    "tenant1.",
+		MaxLength: 20,
+		Allowed:   ChannelChars("._-")})
+	channel, err := v.Validate(" Chat-Room_1 ")
+	assert.Nil(t, err)
+	assert.Equal(t, channel, "tenant1.chat-room_1")
+	channel, err = v.Validate("tenant1.news")
+	assert.Nil(t, err)
+	assert.Equal(t, channel, "tenant1.news")
+	for channel, message := range map[string]string{
+		"  ":             "must not be empty",
+		"a/b":            `invalid character '/'`,
+		"ü":              `invalid character 'ü'`,
+		"fourteen-chars": "longer than 20 bytes"} {
+		_, err := v.Validate(channel)
+		assert.NotNil(t, err, channel)
+		assert.Contains(t, err.Error(), message)
+	}
+	_, err = NewChannelValidator(ChannelConfig{MaxLength: 3}).Validate("abcd")
+	assert.NotNil(t, err)
+}
+
+func TestChannelValidatorInterceptor(t *testing.T) {
+	pubCallResults = nil
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	pcc.AddInterceptor(NewChannelValidator(ChannelConfig{
+		Prefix: "tenant1."}).Interceptor())
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	assert.Nil(t, pcc.Publish("chan", item))
+	assert.Equal(t, len(pubCallResults), 3)
+	items := pubCallResults[2].([]map[string]interface{})
+	assert.Equal(t, items[0]["channel"], "tenant1.chan")
+
+	pubCallResults = nil
+	err := pcc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: item}, {Channel: "", Item: item}})
+	_, ok := err.(*ChannelError)
+	assert.True(t, ok)
+	assert.Nil(t, pubCallResults)
+}
+
+func TestPcPublishValidatesChannels(t *testing.T) {
+	published := 0
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		published++
+		return nil
+	})
+	listener := &testListener{}
+	pc.SetListener(listener)
+	for _, channel := range []string{"", "a\nb", "\xff"} {
+		err := pc.PublishBatch(context.Background(), []ChannelItem{
+			{Channel: "valid", Item: newPolicyTestItem()},
+			{Channel: channel, Item: newPolicyTestItem()}})
+		channelErr, ok := err.(*ChannelError)
+		assert.True(t, ok, channel)
+		assert.Equal(t, channelErr.Channel(), channel)
+	}
+	assert.Equal(t, published, 0)
+	assert.Equal(t, len(listener.drops), 6)
+	assert.Nil(t, pc.Publish("any channel/ünïcode", newPolicyTestItem()))
+	assert.Equal(t, published, 1)
+}
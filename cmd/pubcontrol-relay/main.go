@@ -0,0 +1,178 @@
+//    main.go
+//    ~~~~~~~~~
+//    This module implements the pubcontrol-relay command.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+// Command pubcontrol-relay runs a local EPCP endpoint that re-publishes
+// every received item to the upstream endpoints of a configuration file,
+// so that services can publish without knowing the upstream credentials.
+//
+// Usage:
+//
+//	pubcontrol-relay -config upstreams.yaml [flags]
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/fanout/go-pubcontrol/relay"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The relayServer struct holds the parts of a configured relay.
+type relayServer struct {
+	server  *http.Server
+	watcher *pubcontrol.ConfigWatcher
+	logger  *slog.Logger
+}
+
+func main() {
+	relay, err := newRelayServer(os.Args[1:], os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "pubcontrol-relay:", err)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	defer stop()
+	if err := relay.run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "pubcontrol-relay:", err)
+		os.Exit(1)
+	}
+}
+
+// An internal function that creates a relay from the command-line
+// arguments.
+func newRelayServer(args []string, stderr io.Writer) (*relayServer, error) {
+	fs := flag.NewFlagSet("pubcontrol-relay", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	listen := fs.String("listen", "localhost:5561", "`address` to listen on")
+	config := fs.String("config", "",
+		"JSON or YAML upstream configuration `file` (required)")
+	reload := fs.Duration("reload", 5*time.Second,
+		"interval at which the configuration file is checked for changes, "+
+			"0 to disable")
+	policy := fs.String("policy", "all",
+		"upstream publish policy: all, any, quorum or failover")
+	user := fs.String("auth-user", "", "basic auth user required from clients")
+	pass := fs.String("auth-pass", "", "basic auth password required from clients")
+	token := fs.String("auth-token", "", "bearer token required from clients")
+	iss := fs.String("auth-iss", "", "JWT issuer required from clients")
+	key := fs.String("auth-key", "",
+		"JWT key required from clients, prefixed with 'base64:' if encoded")
+	maxBody := fs.Int64("max-body", relay.DefaultMaxBodySize,
+		"maximum request body size in bytes")
+	debug := fs.Bool("debug", false, "log every publish")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *config == "" {
+		fs.Usage()
+		return nil, errors.New("-config is required")
+	}
+	publishPolicy, err := parsePolicy(*policy)
+	if err != nil {
+		return nil, err
+	}
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(stderr,
+		&slog.HandlerOptions{Level: level}))
+
+	pc := pubcontrol.NewPubControl(nil)
+	pc.SetLogger(logger)
+	pc.SetPublishPolicy(publishPolicy)
+	watcher := pubcontrol.NewConfigWatcher(pc, *config, *reload)
+	if _, err := watcher.Reload(); err != nil {
+		return nil, err
+	}
+	if len(pc.Clients()) == 0 {
+		return nil, errors.New("No upstream endpoints in " + *config)
+	}
+
+	handler := relay.NewHandler(pc)
+	handler.SetMaxBodySize(*maxBody)
+	verifiers := make([]pubcontrol.AuthVerifier, 0)
+	if *user != "" {
+		verifiers = append(verifiers, pubcontrol.VerifyBasic(*user, *pass))
+	}
+	if *token != "" {
+		verifiers = append(verifiers, pubcontrol.VerifyBearer(*token))
+	}
+	if *key != "" {
+		decoded, err := pubcontrol.DecodeKey(*key)
+		if err != nil {
+			return nil, err
+		}
+		verifiers = append(verifiers, pubcontrol.VerifyJwt(*iss, decoded))
+	} else if *iss != "" {
+		return nil, errors.New("-auth-iss requires -auth-key")
+	}
+	handler.SetAuth(verifiers...)
+	if len(verifiers) == 0 {
+		logger.Warn("Accepting publish requests without authentication")
+	}
+
+	r := &relayServer{server: &http.Server{Addr: *listen, Handler: handler,
+		ReadHeaderTimeout: 10 * time.Second}, logger: logger}
+	if *reload > 0 {
+		r.watcher = watcher
+	}
+	return r, nil
+}
+
+// An internal method that serves requests until the context is done and
+// then shuts the server down gracefully.
+func (r *relayServer) run(ctx context.Context) error {
+	if r.watcher != nil {
+		if err := r.watcher.Start(); err != nil {
+			return err
+		}
+		defer r.watcher.Stop()
+	}
+	errs := make(chan error, 1)
+	go func() {
+		r.logger.Info("Relay listening", slog.String("address", r.server.Addr))
+		errs <- r.server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(),
+		30*time.Second)
+	defer cancel()
+	return r.server.Shutdown(shutdownCtx)
+}
+
+// An internal function that returns the publish policy with the
+// specified name.
+func parsePolicy(name string) (pubcontrol.PublishPolicy, error) {
+	switch name {
+	case "all":
+		return pubcontrol.PolicyAll(), nil
+	case "any":
+		return pubcontrol.PolicyAny(), nil
+	case "quorum":
+		return pubcontrol.PolicyQuorum(0), nil
+	case "failover":
+		return pubcontrol.PolicyFailover(), nil
+	}
+	return pubcontrol.PublishPolicy{}, fmt.Errorf("Unknown policy %q", name)
+}
@@ -0,0 +1,107 @@
+//    main_test.go
+//    ~~~~~~~~~
+//    This module implements the pubcontrol-relay tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"context"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/fanout/go-pubcontrol/pubcontroltest"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testFormat struct{}
+
+func (f *testFormat) Name() string {
+	return "ws-message"
+}
+
+func (f *testFormat) Export() interface{} {
+	return map[string]interface{}{"content": "hello"}
+}
+
+func writeTestConfig(t *testing.T, uri string) string {
+	path := filepath.Join(t.TempDir(), "upstreams.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte("- uri: "+uri+
+		"\n  iss: realm\n  key: base64:a2V5\n"), 0644))
+	return path
+}
+
+func TestRelayServer(t *testing.T) {
+	upstream := pubcontroltest.NewServer()
+	defer upstream.Close()
+	upstream.RequireJwt("realm", []byte("key"))
+	r, err := newRelayServer([]string{"-config",
+		writeTestConfig(t, upstream.URL()), "-auth-iss", "local",
+		"-auth-key", "secret", "-policy", "failover"}, io.Discard)
+	assert.Nil(t, err)
+	server := httptest.NewServer(r.server.Handler)
+	defer server.Close()
+	pcc := pubcontrol.NewPubControlClient(server.URL)
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "local"}, []byte("secret"))
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{&testFormat{}}, "", "")
+	assert.Nil(t, pcc.Publish("chan", item))
+	upstream.AssertFormat(t, "chan", "ws-message",
+		map[string]string{"content": "hello"})
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "other"}, []byte("secret"))
+	assert.NotNil(t, pcc.Publish("chan", item))
+	upstream.AssertItemCount(t, 1)
+}
+
+func TestRelayServerRun(t *testing.T) {
+	upstream := pubcontroltest.NewServer()
+	defer upstream.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+	r, err := newRelayServer([]string{"-config",
+		writeTestConfig(t, upstream.URL()), "-listen", addr,
+		"-auth-token", "token"}, io.Discard)
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.run(ctx)
+	}()
+	pcc := pubcontrol.NewPubControlClient("http://" + addr)
+	pcc.SetAuthBearer("token")
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{&testFormat{}}, "", "")
+	deadline := time.Now().Add(5 * time.Second)
+	for pcc.Publish("chan", item) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	upstream.AssertItemCount(t, 1)
+	cancel()
+	assert.Nil(t, <-done)
+}
+
+func TestRelayServerFlags(t *testing.T) {
+	_, err := newRelayServer(nil, io.Discard)
+	assert.NotNil(t, err)
+	config := writeTestConfig(t, "http://localhost")
+	_, err = newRelayServer([]string{"-config", config, "-policy", "some"},
+		io.Discard)
+	assert.NotNil(t, err)
+	_, err = newRelayServer([]string{"-config", config, "-auth-iss", "realm"},
+		io.Discard)
+	assert.NotNil(t, err)
+	_, err = newRelayServer([]string{"-config", config, "-auth-key",
+		"base64:!"}, io.Discard)
+	assert.NotNil(t, err)
+	empty := filepath.Join(t.TempDir(), "empty.json")
+	assert.Nil(t, os.WriteFile(empty, []byte("[]"), 0644))
+	_, err = newRelayServer([]string{"-config", empty}, io.Discard)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,340 @@
+//    bench.go
+//    ~~~~~~~~~
+//    This module implements the pubctl bench command.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/fanout/go-pubcontrol/pubcontroltest"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// The benchConfig struct holds the parameters of a benchmark run.
+type benchConfig struct {
+	concurrency int
+	rate        float64
+	duration    time.Duration
+	count       int
+	size        int
+	channels    int
+	batch       int
+	format      string
+	timeout     time.Duration
+}
+
+// An internal function that runs the bench command.
+func runBench(args []string, e *env) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(e.stderr, "Usage: pubctl bench [flags]\n\n"+
+			"Publishes generated items as fast as allowed and reports the "+
+			"throughput,\nlatency and errors per endpoint.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	var endpoints endpointFlags
+	endpoints.register(fs)
+	var cfg benchConfig
+	fs.IntVar(&cfg.concurrency, "concurrency", 4, "number of concurrent publishers")
+	fs.Float64Var(&cfg.rate, "rate", 0, "items per second, 0 for unlimited")
+	fs.DurationVar(&cfg.duration, "duration", 10*time.Second,
+		"how long to publish, 0 to stop after -count items only")
+	fs.IntVar(&cfg.count, "count", 0, "total items to publish, 0 for unlimited")
+	fs.IntVar(&cfg.size, "size", 100, "payload size in bytes")
+	fs.IntVar(&cfg.channels, "channels", 1, "number of channels to publish to")
+	fs.IntVar(&cfg.batch, "batch", 1, "items per request")
+	fs.StringVar(&cfg.format, "format", "http-stream",
+		"item format: http-response, http-stream or ws-message")
+	fs.DurationVar(&cfg.timeout, "timeout", 30*time.Second,
+		"timeout of a single publish")
+	fake := fs.Bool("fake", false,
+		"publish to a built-in fake endpoint instead of real endpoints")
+	fakeLatency := fs.Duration("fake-latency", 0,
+		"response latency of the fake endpoint")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(e.stderr, "pubctl:", err)
+		return exitUsage
+	}
+	if *fake {
+		server := pubcontroltest.NewServer()
+		defer server.Close()
+		server.SetRecording(false)
+		server.SetDefaultResponse(pubcontroltest.Delay(*fakeLatency))
+		endpoints = endpointFlags{uris: stringsFlag{server.URL()}}
+	}
+	pc, err := endpoints.pubControl(e.getenv)
+	if err != nil {
+		fmt.Fprintln(e.stderr, "pubctl:", err)
+		return exitUsage
+	}
+	stats := newBenchStats()
+	pc.SetMetrics(stats)
+	result, err := bench(pc, cfg)
+	if err != nil {
+		fmt.Fprintln(e.stderr, "pubctl:", err)
+		return exitUsage
+	}
+	reportBench(e.stdout, cfg, result, pc.Clients(), stats)
+	if result.succeeded == 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// An internal method that checks the benchmark parameters.
+func (cfg benchConfig) validate() error {
+	switch {
+	case cfg.concurrency < 1:
+		return errors.New("-concurrency must be at least 1")
+	case cfg.batch < 1:
+		return errors.New("-batch must be at least 1")
+	case cfg.channels < 1:
+		return errors.New("-channels must be at least 1")
+	case cfg.size < 0 || cfg.rate < 0 || cfg.count < 0:
+		return errors.New("-size, -rate and -count must not be negative")
+	case cfg.duration <= 0 && cfg.count == 0:
+		return errors.New("-duration or -count is required")
+	}
+	return nil
+}
+
+// The benchResult struct summarizes a benchmark run across all
+// endpoints.
+type benchResult struct {
+	elapsed   time.Duration
+	requests  int64
+	items     int64
+	succeeded int64
+}
+
+// An internal function that publishes generated items with the specified
+// parameters until the duration has passed or the count was reached.
+func bench(pc *pubcontrol.PubControl, cfg benchConfig) (benchResult, error) {
+	format, err := newFormat(cfg.format,
+		[]byte(strings.Repeat("x", cfg.size)), formatOptions{})
+	if err != nil {
+		return benchResult{}, err
+	}
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{format}, "", "")
+	ctx := context.Background()
+	if cfg.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.duration)
+		defer cancel()
+	}
+	var tokens <-chan time.Time
+	if cfg.rate > 0 {
+		// Clamp the interval to what a ticker supports, so that very high
+		// rates are as good as unlimited instead of making it panic.
+		interval := time.Duration(math.Min(math.Max(float64(time.Second)*
+			float64(cfg.batch)/cfg.rate, 1), math.MaxInt64/2))
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+	var result benchResult
+	var claimed int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < cfg.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				size := int64(cfg.batch)
+				first := atomic.AddInt64(&claimed, size) - size
+				if cfg.count > 0 {
+					if first >= int64(cfg.count) {
+						return
+					}
+					if remaining := int64(cfg.count) - first; remaining < size {
+						size = remaining
+					}
+				}
+				if tokens != nil {
+					select {
+					case <-tokens:
+					case <-ctx.Done():
+						return
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+				items := make([]pubcontrol.ChannelItem, size)
+				for i := range items {
+					items[i] = pubcontrol.ChannelItem{Item: item,
+						Channel: "bench-" + strconv.FormatInt(
+							(first+int64(i))%int64(cfg.channels), 10)}
+				}
+				publishCtx, cancel := context.WithTimeout(
+					context.Background(), cfg.timeout)
+				err := pc.PublishBatch(publishCtx, items)
+				cancel()
+				atomic.AddInt64(&result.requests, 1)
+				atomic.AddInt64(&result.items, size)
+				if err == nil {
+					atomic.AddInt64(&result.succeeded, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	result.elapsed = time.Since(start)
+	return result, nil
+}
+
+// The benchStats struct is a MetricsCollector that records the latency
+// and errors of every publish per endpoint.
+type benchStats struct {
+	lock    sync.Mutex
+	clients map[string]*clientStats
+}
+
+// The clientStats struct holds the recorded publishes of one endpoint.
+type clientStats struct {
+	latencies []time.Duration
+	items     int
+	errors    map[string]int
+}
+
+// An internal function that creates empty statistics.
+func newBenchStats() *benchStats {
+	return &benchStats{clients: make(map[string]*clientStats)}
+}
+
+func (s *benchStats) PublishCompleted(client string, items int,
+	duration time.Duration, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats, ok := s.clients[client]
+	if !ok {
+		stats = &clientStats{errors: make(map[string]int)}
+		s.clients[client] = stats
+	}
+	var partial *pubcontrol.PartialPublishError
+	if errors.As(err, &partial) {
+		// A partially delivered publish is reported twice, first for the
+		// delivered items, so only its error is recorded here.
+		stats.errors[errorKind(err)]++
+		return
+	}
+	stats.latencies = append(stats.latencies, duration)
+	if err != nil {
+		stats.errors[errorKind(err)]++
+	} else {
+		stats.items += items
+	}
+}
+
+func (s *benchStats) RequestCompleted(client string, statusCode int,
+	bytesSent int, duration time.Duration, err error) {
+}
+
+func (s *benchStats) QueueDepthChanged(client string, depth int) {}
+
+func (s *benchStats) Retried(client string) {}
+
+// An internal method that returns the statistics of a client, or nil if
+// it has not published.
+func (s *benchStats) client(label string) *clientStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.clients[label]
+}
+
+// An internal function that returns the category of a publish error used
+// in the error breakdown.
+func errorKind(err error) string {
+	var publishErr *pubcontrol.PublishError
+	switch {
+	case errors.As(err, &publishErr) && publishErr.StatusCode() != 0:
+		return "HTTP " + strconv.Itoa(publishErr.StatusCode())
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+// An internal function that prints the benchmark report.
+func reportBench(w io.Writer, cfg benchConfig, result benchResult,
+	clients []*pubcontrol.PubControlClient, stats *benchStats) {
+	seconds := result.elapsed.Seconds()
+	fmt.Fprintf(w, "Published %d item(s) in %d request(s) over %.2fs "+
+		"(%.1f items/s) with concurrency %d, batch %d, %d byte payload, "+
+		"%d channel(s)\n\n", result.items, result.requests, seconds,
+		float64(result.items)/seconds, cfg.concurrency, cfg.batch, cfg.size,
+		cfg.channels)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tREQUESTS\tITEMS/S\tP50\tP90\tP99\tMAX\tERRORS")
+	breakdown := make([]string, 0)
+	for _, pcc := range clients {
+		label := pcc.Name()
+		if label == "" {
+			label = pcc.Uri()
+		}
+		cs := stats.client(label)
+		if cs == nil {
+			cs = &clientStats{}
+		}
+		sort.Slice(cs.latencies, func(i, j int) bool {
+			return cs.latencies[i] < cs.latencies[j]
+		})
+		failed := 0
+		kinds := make([]string, 0, len(cs.errors))
+		for kind, count := range cs.errors {
+			failed += count
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			breakdown = append(breakdown, fmt.Sprintf("  %s: %s x%d",
+				pubcontrol.RedactUri(label), kind, cs.errors[kind]))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%s\t%s\t%s\t%s\t%d\n",
+			pubcontrol.RedactUri(label), len(cs.latencies),
+			float64(cs.items)/seconds, percentile(cs.latencies, 0.5),
+			percentile(cs.latencies, 0.9), percentile(cs.latencies, 0.99),
+			percentile(cs.latencies, 1), failed)
+	}
+	tw.Flush()
+	if len(breakdown) > 0 {
+		fmt.Fprintln(w, "\nErrors:")
+		fmt.Fprintln(w, strings.Join(breakdown, "\n"))
+	}
+}
+
+// An internal function that formats the specified percentile of sorted
+// latencies in milliseconds.
+func percentile(sorted []time.Duration, p float64) string {
+	if len(sorted) == 0 {
+		return "-"
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return fmt.Sprintf("%.2fms",
+		float64(sorted[i].Microseconds())/1000)
+}
@@ -0,0 +1,96 @@
+//    bench_test.go
+//    ~~~~~~~~~
+//    This module implements the pubctl bench tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"context"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/fanout/go-pubcontrol/pubcontroltest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBenchFake(t *testing.T) {
+	code, stdout, stderr := runTest([]string{"bench", "-fake", "-count", "20",
+		"-concurrency", "2", "-batch", "3", "-channels", "4"}, "", nil)
+	assert.Equal(t, code, exitOK, stderr)
+	assert.True(t, strings.Contains(stdout,
+		"Published 20 item(s) in 7 request(s)"), stdout)
+	assert.True(t, strings.Contains(stdout, "ENDPOINT"))
+	assert.False(t, strings.Contains(stdout, "Errors:"))
+}
+
+func TestBenchRate(t *testing.T) {
+	start := time.Now()
+	code, _, _ := runTest([]string{"bench", "-fake", "-count", "5",
+		"-rate", "100"}, "", nil)
+	assert.Equal(t, code, exitOK)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	code, _, _ = runTest([]string{"bench", "-fake", "-count", "5",
+		"-rate", "1e10"}, "", nil)
+	assert.Equal(t, code, exitOK)
+}
+
+func TestBenchErrors(t *testing.T) {
+	server := pubcontroltest.NewServer()
+	defer server.Close()
+	server.SetRecording(false)
+	server.SetDefaultResponse(pubcontroltest.TooManyRequests(1))
+	server.Respond(pubcontroltest.Response{})
+	code, stdout, _ := runTest([]string{"bench", "-uri", server.URL(),
+		"-count", "4", "-concurrency", "1"}, "", nil)
+	assert.Equal(t, code, exitOK)
+	assert.True(t, strings.Contains(stdout, "Errors:\n  "+server.URL()+
+		": HTTP 429 x3\n"), stdout)
+	server.SetDefaultResponse(pubcontroltest.Status(500, "error"))
+	code, _, _ = runTest([]string{"bench", "-uri", server.URL(),
+		"-count", "1"}, "", nil)
+	assert.Equal(t, code, exitFailure)
+}
+
+func TestBenchStatsPartial(t *testing.T) {
+	server := pubcontroltest.NewServer()
+	defer server.Close()
+	server.Respond(pubcontroltest.Response{},
+		pubcontroltest.Status(500, "error"))
+	stats := newBenchStats()
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+	pcc.SetMetrics(stats)
+	pcc.SetMaxRequestSize(80)
+	format, err := newFormat("ws-message", []byte("x"), formatOptions{})
+	assert.Nil(t, err)
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{format}, "", "")
+	err = pcc.PublishBatch(context.Background(), []pubcontrol.ChannelItem{
+		{Channel: "a", Item: item}, {Channel: "b", Item: item}})
+	_, ok := err.(*pubcontrol.PartialPublishError)
+	assert.True(t, ok)
+	cs := stats.client(server.URL())
+	assert.Equal(t, len(cs.latencies), 1)
+	assert.Equal(t, cs.items, 1)
+	assert.Equal(t, cs.errors, map[string]int{"HTTP 500": 1})
+}
+
+func TestBenchUsage(t *testing.T) {
+	code, _, stderr := runTest([]string{"bench", "-fake", "-duration", "0"},
+		"", nil)
+	assert.Equal(t, code, exitUsage)
+	assert.True(t, strings.Contains(stderr, "-duration or -count"))
+	code, _, _ = runTest([]string{"bench", "-fake", "-batch", "0"}, "", nil)
+	assert.Equal(t, code, exitUsage)
+}
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{time.Millisecond, 2 * time.Millisecond,
+		3 * time.Millisecond, 4 * time.Millisecond}
+	assert.Equal(t, percentile(latencies, 0.5), "2.00ms")
+	assert.Equal(t, percentile(latencies, 0.99), "4.00ms")
+	assert.Equal(t, percentile(latencies, 0), "1.00ms")
+	assert.Equal(t, percentile(nil, 0.5), "-")
+}
@@ -0,0 +1,146 @@
+//    endpoints.go
+//    ~~~~~~~~~
+//    This module implements the pubctl endpoint configuration.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/fanout/go-pubcontrol"
+	"net/url"
+	"strings"
+)
+
+// An internal type used to collect repeated string flags.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// The endpointFlags struct holds the flags that select the endpoints and
+// their authentication.
+type endpointFlags struct {
+	uris   stringsFlag
+	config string
+	iss    string
+	key    string
+	user   string
+	pass   string
+	token  string
+}
+
+// An internal method that registers the endpoint flags.
+func (f *endpointFlags) register(fs *flag.FlagSet) {
+	fs.Var(&f.uris, "uri", "endpoint `URI`, may be repeated")
+	fs.StringVar(&f.config, "config", "",
+		"JSON or YAML endpoint configuration `file`")
+	fs.StringVar(&f.iss, "iss", "", "JWT issuer claim")
+	fs.StringVar(&f.key, "key", "",
+		"JWT key, prefixed with 'base64:' if encoded")
+	fs.StringVar(&f.user, "user", "", "basic auth user")
+	fs.StringVar(&f.pass, "pass", "", "basic auth password")
+	fs.StringVar(&f.token, "token", "", "bearer token")
+}
+
+// An internal method that creates a PubControl instance for the selected
+// endpoints. The -uri flags take precedence over the configuration file,
+// which takes precedence over the GRIP_URL environment variable.
+func (f *endpointFlags) pubControl(getenv func(string) string) (
+	*pubcontrol.PubControl, error) {
+	pc := pubcontrol.NewPubControl(nil)
+	switch {
+	case len(f.uris) > 0:
+		for _, uri := range f.uris {
+			pcc, err := f.client(uri, "", "")
+			if err != nil {
+				return nil, err
+			}
+			pc.AddClient(pcc)
+		}
+	case f.config != "":
+		config, err := pubcontrol.LoadConfigFile(f.config)
+		if err != nil {
+			return nil, err
+		}
+		pc.ApplyConfig(config)
+	case getenv("GRIP_URL") != "":
+		uri, iss, key, err := parseGripUrl(getenv("GRIP_URL"))
+		if err != nil {
+			return nil, err
+		}
+		pcc, err := f.client(uri, iss, key)
+		if err != nil {
+			return nil, err
+		}
+		pc.AddClient(pcc)
+	default:
+		return nil, errors.New("No endpoints: use -uri, -config or GRIP_URL")
+	}
+	if len(pc.Clients()) == 0 {
+		return nil, errors.New("No endpoints configured")
+	}
+	return pc, nil
+}
+
+// An internal method that creates a client for the specified URI using
+// the authentication flags. The issuer and key are used for JWT auth
+// unless overridden by the flags.
+func (f *endpointFlags) client(uri, iss, key string) (
+	*pubcontrol.PubControlClient, error) {
+	if f.iss != "" {
+		iss = f.iss
+	}
+	if f.key != "" {
+		key = f.key
+	}
+	pcc := pubcontrol.NewPubControlClient(uri)
+	switch {
+	case f.user != "":
+		pcc.SetAuthBasic(f.user, f.pass)
+	case iss != "":
+		if key == "" {
+			return nil, errors.New("JWT auth requires -key")
+		}
+		decoded, err := pubcontrol.DecodeKey(key)
+		if err != nil {
+			return nil, err
+		}
+		pcc.SetAuthJwt(map[string]interface{}{"iss": iss}, decoded)
+	case f.token != "":
+		pcc.SetAuthBearer(f.token)
+	case key != "":
+		pcc.SetAuthBearer(key)
+	}
+	return pcc, nil
+}
+
+// An internal function that splits a GRIP URL such as
+// https://api.fanout.io/realm/123?iss=123&key=base64:abc into the
+// endpoint URI, the JWT issuer and the key.
+func parseGripUrl(gripUrl string) (uri, iss, key string, err error) {
+	parsed, err := url.Parse(gripUrl)
+	if err != nil {
+		return "", "", "", err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", "", "", errors.New("Invalid GRIP_URL: " + gripUrl)
+	}
+	query := parsed.Query()
+	iss = query.Get("iss")
+	// Query decoding turns the '+' of unescaped base64 keys into spaces.
+	key = strings.ReplaceAll(query.Get("key"), " ", "+")
+	query.Del("iss")
+	query.Del("key")
+	parsed.RawQuery = query.Encode()
+	return strings.TrimSuffix(parsed.String(), "/"), iss, key, nil
+}
@@ -0,0 +1,75 @@
+//    format.go
+//    ~~~~~~~~~
+//    This module implements the pubctl item formats.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/fanout/go-pubcontrol"
+	"unicode/utf8"
+)
+
+// The formatOptions struct holds the format flags that apply in addition
+// to the content.
+type formatOptions struct {
+	code  int
+	close bool
+}
+
+// The format struct is a generic Formatter for the formats supported by
+// the tool.
+type format struct {
+	name   string
+	export map[string]interface{}
+}
+
+func (f *format) Name() string {
+	return f.name
+}
+
+func (f *format) Export() interface{} {
+	return f.export
+}
+
+// An internal function that creates the Formatter with the specified
+// name for the specified content. Content that is not valid UTF-8 is
+// sent base64-encoded using the binary variant of the content field.
+func newFormat(name string, content []byte,
+	options formatOptions) (pubcontrol.Formatter, error) {
+	export := make(map[string]interface{})
+	switch name {
+	case "http-response":
+		setContent(export, "body", content)
+		if options.code != 0 {
+			export["code"] = options.code
+		}
+	case "http-stream":
+		if options.close {
+			export["action"] = "close"
+		} else {
+			setContent(export, "content", content)
+		}
+	case "ws-message":
+		setContent(export, "content", content)
+	default:
+		return nil, fmt.Errorf("Unsupported format %q: use http-response, "+
+			"http-stream or ws-message", name)
+	}
+	return &format{name: name, export: export}, nil
+}
+
+// An internal function that sets the content field of an export, or its
+// '-bin' variant if the content is not valid UTF-8.
+func setContent(export map[string]interface{}, field string,
+	content []byte) {
+	if utf8.Valid(content) {
+		export[field] = string(content)
+	} else {
+		export[field+"-bin"] = base64.StdEncoding.EncodeToString(content)
+	}
+}
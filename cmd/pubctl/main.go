@@ -0,0 +1,79 @@
+//    main.go
+//    ~~~~~~~~~
+//    This module implements the pubctl command-line tool.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+// Command pubctl publishes messages to EPCP endpoints such as Pushpin or
+// Fanout Cloud from the command line.
+//
+// Usage:
+//
+//	pubctl publish [flags] <channel> [content...]
+//	pubctl bench [flags]
+//
+// Run a subcommand with -h to list its flags.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// The exit codes of the tool.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// The env struct holds the standard streams and environment of a single
+// run so that the tool can be tested without a process.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(key string) string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], &env{stdin: os.Stdin, stdout: os.Stdout,
+		stderr: os.Stderr, getenv: os.Getenv}))
+}
+
+// An internal function that runs the tool with the specified arguments
+// and returns the exit code.
+func run(args []string, e *env) int {
+	if len(args) == 0 {
+		usage(e.stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "publish":
+		return runPublish(args[1:], e)
+	case "bench":
+		return runBench(args[1:], e)
+	case "help", "-h", "-help", "--help":
+		usage(e.stdout)
+		return exitOK
+	default:
+		fmt.Fprintf(e.stderr, "pubctl: unknown command %q\n", args[0])
+		usage(e.stderr)
+		return exitUsage
+	}
+}
+
+// An internal function that prints the usage of the tool.
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: pubctl <command> [flags] [arguments]
+
+Commands:
+  publish   publish content to a channel on the configured endpoints
+  bench     measure the publish throughput and latency of the endpoints
+
+Endpoints are read from -uri flags, a -config file or the GRIP_URL
+environment variable, in that order of precedence.
+`)
+}
@@ -0,0 +1,127 @@
+//    main_test.go
+//    ~~~~~~~~~
+//    This module implements the pubctl tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"bytes"
+	"github.com/fanout/go-pubcontrol/pubcontroltest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runTest(args []string, stdin string,
+	vars map[string]string) (int, string, string) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	code := run(args, &env{stdin: strings.NewReader(stdin), stdout: stdout,
+		stderr: stderr, getenv: func(key string) string {
+			return vars[key]
+		}})
+	return code, stdout.String(), stderr.String()
+}
+
+func TestPublishArgs(t *testing.T) {
+	server := pubcontroltest.NewServer()
+	defer server.Close()
+	server.RequireBearer("token")
+	code, stdout, _ := runTest([]string{"publish", "-uri", server.URL(),
+		"-token", "token", "-id", "1", "-code", "201", "chan", "hello",
+		"world"}, "", nil)
+	assert.Equal(t, code, exitOK)
+	assert.Equal(t, stdout, "OK   "+server.URL()+"\n")
+	server.AssertFormat(t, "chan", "http-response",
+		map[string]interface{}{"body": "hello world", "code": 201})
+	assert.Equal(t, server.Items()[0].Id, "1")
+}
+
+func TestPublishStdinAndFile(t *testing.T) {
+	server := pubcontroltest.NewServer()
+	defer server.Close()
+	code, _, _ := runTest([]string{"publish", "-uri", server.URL(),
+		"-format", "ws-message", "chan"}, "from stdin", nil)
+	assert.Equal(t, code, exitOK)
+	server.AssertFormat(t, "chan", "ws-message",
+		map[string]string{"content": "from stdin"})
+
+	path := filepath.Join(t.TempDir(), "content")
+	assert.Nil(t, os.WriteFile(path, []byte{0xff, 0x00}, 0644))
+	code, _, _ = runTest([]string{"publish", "-uri", server.URL(),
+		"-format", "http-stream", "-file", path, "chan"}, "", nil)
+	assert.Equal(t, code, exitOK)
+	server.AssertFormat(t, "chan", "http-stream",
+		map[string]string{"content-bin": "/wA="})
+
+	code, _, _ = runTest([]string{"publish", "-uri", server.URL(),
+		"-format", "http-stream", "-close", "chan"}, "", nil)
+	assert.Equal(t, code, exitOK)
+	server.AssertFormat(t, "chan", "http-stream",
+		map[string]string{"action": "close"})
+}
+
+func TestPublishGripUrl(t *testing.T) {
+	server := pubcontroltest.NewServer()
+	defer server.Close()
+	server.RequireJwt("realm", []byte("key"))
+	code, stdout, stderr := runTest([]string{"publish", "chan", "body"}, "",
+		map[string]string{"GRIP_URL": server.URL() +
+			"/?iss=realm&key=base64:a2V5"})
+	assert.Equal(t, code, exitOK, stderr)
+	assert.Equal(t, stdout, "OK   "+server.URL()+"\n")
+	server.AssertItemCount(t, 1)
+}
+
+func TestPublishConfigPartialFailure(t *testing.T) {
+	good := pubcontroltest.NewServer()
+	defer good.Close()
+	bad := pubcontroltest.NewServer()
+	defer bad.Close()
+	bad.RequireBasic("user", "pass")
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`[{"uri": "`+good.URL()+
+		`", "name": "good"}, {"uri": "`+bad.URL()+`", "name": "bad"}]`),
+		0644))
+	code, stdout, _ := runTest([]string{"publish", "-config", path, "chan",
+		"body"}, "", nil)
+	assert.Equal(t, code, exitFailure)
+	assert.True(t, strings.Contains(stdout, "OK   good ("+good.URL()+")\n"))
+	assert.True(t, strings.Contains(stdout, "FAIL bad ("+bad.URL()+
+		"): Failure status code: 401"))
+	good.AssertItemCount(t, 1)
+}
+
+func TestPublishUsage(t *testing.T) {
+	code, _, stderr := runTest(nil, "", nil)
+	assert.Equal(t, code, exitUsage)
+	assert.True(t, strings.Contains(stderr, "Usage: pubctl"))
+	code, _, _ = runTest([]string{"unknown"}, "", nil)
+	assert.Equal(t, code, exitUsage)
+	code, _, stderr = runTest([]string{"publish", "chan", "body"}, "", nil)
+	assert.Equal(t, code, exitUsage)
+	assert.True(t, strings.Contains(stderr, "No endpoints"))
+	code, _, stderr = runTest([]string{"publish", "-uri", "http://localhost",
+		"-format", "xml", "chan", "body"}, "", nil)
+	assert.Equal(t, code, exitUsage)
+	assert.True(t, strings.Contains(stderr, "Unsupported format"))
+	code, _, _ = runTest([]string{"publish", "-uri", "http://localhost",
+		"-iss", "realm", "chan", "body"}, "", nil)
+	assert.Equal(t, code, exitUsage)
+}
+
+func TestParseGripUrl(t *testing.T) {
+	uri, iss, key, err := parseGripUrl(
+		"https://api.fanout.io/realm/123?iss=123&key=base64:a+b/")
+	assert.Nil(t, err)
+	assert.Equal(t, uri, "https://api.fanout.io/realm/123")
+	assert.Equal(t, iss, "123")
+	assert.Equal(t, key, "base64:a+b/")
+	_, _, _, err = parseGripUrl("realm")
+	assert.NotNil(t, err)
+}
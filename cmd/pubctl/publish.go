@@ -0,0 +1,125 @@
+//    publish.go
+//    ~~~~~~~~~
+//    This module implements the pubctl publish command.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/fanout/go-pubcontrol"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// An internal function that runs the publish command.
+func runPublish(args []string, e *env) int {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(e.stderr, "Usage: pubctl publish [flags] <channel> "+
+			"[content...]\n\nThe content is read from the arguments, the "+
+			"-file flag or stdin.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	var endpoints endpointFlags
+	endpoints.register(fs)
+	formatName := fs.String("format", "http-response",
+		"item format: http-response, http-stream or ws-message")
+	file := fs.String("file", "", "read the content from `path`, '-' for stdin")
+	id := fs.String("id", "", "item ID")
+	prevId := fs.String("prev-id", "", "previous item ID")
+	code := fs.Int("code", 0, "HTTP status code for http-response items")
+	closeStream := fs.Bool("close", false,
+		"close the stream instead of sending content (http-stream)")
+	timeout := fs.Duration("timeout", 30*time.Second, "publish timeout")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	channel := fs.Arg(0)
+	content, err := readContent(fs.Args()[1:], *file, *closeStream, e.stdin)
+	if err != nil {
+		fmt.Fprintln(e.stderr, "pubctl:", err)
+		return exitUsage
+	}
+	format, err := newFormat(*formatName, content,
+		formatOptions{code: *code, close: *closeStream})
+	if err != nil {
+		fmt.Fprintln(e.stderr, "pubctl:", err)
+		return exitUsage
+	}
+	pc, err := endpoints.pubControl(e.getenv)
+	if err != nil {
+		fmt.Fprintln(e.stderr, "pubctl:", err)
+		return exitUsage
+	}
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{format}, *id, *prevId)
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	err = pc.PublishContext(ctx, channel, item)
+	return reportResults(e.stdout, pc.Clients(), err)
+}
+
+// An internal function that reads the content to publish from the
+// arguments, the specified file or stdin, in that order of precedence.
+// No content is needed to close a stream.
+func readContent(args []string, file string, closeStream bool,
+	stdin io.Reader) ([]byte, error) {
+	switch {
+	case len(args) > 0:
+		return []byte(strings.Join(args, " ")), nil
+	case file == "-":
+		return io.ReadAll(stdin)
+	case file != "":
+		return os.ReadFile(file)
+	case closeStream:
+		return nil, nil
+	default:
+		return io.ReadAll(stdin)
+	}
+}
+
+// An internal function that prints the result of a publish for every
+// client and returns the exit code.
+func reportResults(w io.Writer, clients []*pubcontrol.PubControlClient,
+	err error) int {
+	failed := make(map[*pubcontrol.PubControlClient]error)
+	if pcErr, ok := err.(*pubcontrol.PubControlError); ok {
+		for _, clientErr := range pcErr.Errors() {
+			failed[clientErr.Client] = clientErr.Err
+		}
+	} else if err != nil {
+		for _, pcc := range clients {
+			failed[pcc] = err
+		}
+	}
+	for _, pcc := range clients {
+		label := pubcontrol.RedactUri(pcc.Uri())
+		if name := pcc.Name(); name != "" {
+			label = name + " (" + label + ")"
+		}
+		if clientErr, ok := failed[pcc]; ok {
+			fmt.Fprintf(w, "FAIL %s: %s\n", label,
+				strings.TrimSpace(clientErr.Error()))
+		} else {
+			fmt.Fprintf(w, "OK   %s\n", label)
+		}
+	}
+	if err != nil {
+		return exitFailure
+	}
+	return exitOK
+}
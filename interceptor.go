@@ -0,0 +1,194 @@
+//    interceptor.go
+//    ~~~~~~~~~
+//    This module implements the Interceptor functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// A PublishFunc function publishes the specified item to the specified
+// channel. It is passed to an Interceptor as the next step of the chain.
+type PublishFunc func(ctx context.Context, channel string, item *Item) error
+
+// An Interceptor function is called for every item before it is published
+// and decides what happens to it. It may modify the channel or item and
+// pass them on by calling next, return an error to fail the publish, or
+// drop the item by returning nil without calling next. The error returned
+// by next is the result of the publish and may be inspected or replaced.
+// Next must be called at most once.
+//
+// Interceptors are applied to Publish, PublishContext and PublishBatch
+// alike. For batches every item passes through the chain separately and
+// the items that reach the end of the chain are published together, after
+// which every pending call to next returns the result of that publish.
+// The context passed to next is used for that publish when every item of
+// the batch reaching the end of the chain was passed the same context.
+// Otherwise, as the contexts of different items cannot be merged, the
+// batch is published with the context it was published with originally
+// and values or deadlines added by interceptors are ignored.
+type Interceptor func(ctx context.Context, channel string, item *Item,
+	next PublishFunc) error
+
+// An internal type used for the function that publishes the items that
+// made it through the interceptor chain.
+type batchSender func(ctx context.Context, items []ChannelItem) error
+
+// An internal function that passes every item through the specified
+// interceptors, the first of which is outermost, and publishes the items
+// reaching the end of the chain using the send function. The optional
+// dropped function is called for every item dropped by an interceptor.
+func intercept(ctx context.Context, interceptors []Interceptor,
+	items []ChannelItem, send batchSender,
+	dropped func(entry ChannelItem)) error {
+	if len(interceptors) == 0 || len(items) == 0 {
+		return send(ctx, items)
+	}
+	b := newInterceptedBatch(len(items))
+	results := make([]error, len(items))
+	var wg sync.WaitGroup
+	for i := range items {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				if err := recover(); err != nil {
+					results[i] = fmt.Errorf("Interceptor panic: %v", err)
+				}
+				b.leave(i, results[i])
+			}()
+			results[i] = chain(interceptors, b.terminal(i))(ctx,
+				items[i].Channel, items[i].Item)
+		}(i)
+	}
+	<-b.ready
+	if b.abort == nil {
+		batch := make([]ChannelItem, 0, len(items))
+		var sendCtx context.Context
+		for i, entry := range b.arrived {
+			if entry == nil {
+				if dropped != nil {
+					dropped(items[i])
+				}
+				continue
+			}
+			batch = append(batch, *entry)
+			if sendCtx == nil {
+				sendCtx = b.ctxs[i]
+			} else if sendCtx != b.ctxs[i] {
+				sendCtx = ctx
+			}
+		}
+		if len(batch) > 0 {
+			b.result = send(sendCtx, batch)
+		}
+	} else {
+		b.result = b.abort
+	}
+	close(b.sent)
+	wg.Wait()
+	for _, err := range results {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// An internal function that builds a PublishFunc calling the specified
+// interceptors in order and then the terminal function.
+func chain(interceptors []Interceptor, terminal PublishFunc) PublishFunc {
+	next := terminal
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor := interceptors[i]
+		inner := next
+		next = func(ctx context.Context, channel string, item *Item) error {
+			return interceptor(ctx, channel, item, inner)
+		}
+	}
+	return next
+}
+
+// The states of an item in an intercepted batch.
+const (
+	itemPending = iota
+	itemArrived
+	itemLeft
+)
+
+// An internal struct used to collect the items of a batch that reach the
+// end of the interceptor chain.
+type interceptedBatch struct {
+	lock    sync.Mutex
+	pending int
+	states  []int
+	arrived []*ChannelItem
+	ctxs    []context.Context
+	abort   error
+	result  error
+	ready   chan struct{}
+	sent    chan struct{}
+}
+
+// An internal function that creates a batch for the specified number of
+// items.
+func newInterceptedBatch(count int) *interceptedBatch {
+	return &interceptedBatch{pending: count, states: make([]int, count),
+		arrived: make([]*ChannelItem, count),
+		ctxs:    make([]context.Context, count),
+		ready:   make(chan struct{}), sent: make(chan struct{})}
+}
+
+// An internal method that returns the terminal function of the chain for
+// the item with the specified index. It records the item and waits until
+// the batch has been published.
+func (b *interceptedBatch) terminal(i int) PublishFunc {
+	return func(ctx context.Context, channel string, item *Item) error {
+		b.lock.Lock()
+		if b.states[i] != itemPending {
+			b.lock.Unlock()
+			return errors.New("Interceptor called next more than once " +
+				"or after returning")
+		}
+		b.states[i] = itemArrived
+		b.arrived[i] = &ChannelItem{Channel: channel, Item: item}
+		b.ctxs[i] = ctx
+		b.countDown()
+		b.lock.Unlock()
+		<-b.sent
+		return b.result
+	}
+}
+
+// An internal method called when the chain of the item with the specified
+// index has returned. An item whose chain returned without reaching the
+// terminal function was either dropped or failed; in the latter case the
+// whole batch is aborted.
+func (b *interceptedBatch) leave(i int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.states[i] != itemPending {
+		return
+	}
+	b.states[i] = itemLeft
+	if err != nil && b.abort == nil {
+		b.abort = err
+	}
+	b.countDown()
+}
+
+// An internal method that records that an item has arrived or left. The
+// lock must be held by the caller.
+func (b *interceptedBatch) countDown() {
+	b.pending--
+	if b.pending == 0 {
+		close(b.ready)
+	}
+}
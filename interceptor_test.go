@@ -0,0 +1,180 @@
+//    interceptor_test.go
+//    ~~~~~~~~~
+//    This module implements the Interceptor tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingSender struct {
+	lock    sync.Mutex
+	batches [][]ChannelItem
+	ctxs    []context.Context
+	err     error
+}
+
+func (r *recordingSender) send(ctx context.Context,
+	items []ChannelItem) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.batches = append(r.batches, items)
+	r.ctxs = append(r.ctxs, ctx)
+	return r.err
+}
+
+type testContextKey struct{}
+
+func prefixInterceptor(prefix string) Interceptor {
+	return func(ctx context.Context, channel string, item *Item,
+		next PublishFunc) error {
+		return next(ctx, prefix+channel, item)
+	}
+}
+
+func TestInterceptNone(t *testing.T) {
+	sender := &recordingSender{}
+	items := []ChannelItem{{Channel: "chan"}}
+	assert.Nil(t, intercept(context.Background(), nil, items, sender.send, nil))
+	assert.Equal(t, sender.batches, [][]ChannelItem{items})
+}
+
+func TestInterceptOrder(t *testing.T) {
+	sender := &recordingSender{}
+	items := []ChannelItem{{Channel: "chan"}}
+	err := intercept(context.Background(), []Interceptor{
+		prefixInterceptor("a."), prefixInterceptor("b.")}, items, sender.send, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, sender.batches[0][0].Channel, "b.a.chan")
+}
+
+func TestInterceptContext(t *testing.T) {
+	sender := &recordingSender{}
+	err := intercept(context.Background(), []Interceptor{
+		func(ctx context.Context, channel string, item *Item,
+			next PublishFunc) error {
+			return next(context.WithValue(ctx, testContextKey{}, "value"),
+				channel, item)
+		}}, []ChannelItem{{Channel: "chan"}}, sender.send, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, sender.ctxs[0].Value(testContextKey{}), "value")
+}
+
+func TestInterceptBatchContext(t *testing.T) {
+	sender := &recordingSender{}
+	shared := context.WithValue(context.Background(), testContextKey{},
+		"shared")
+	items := []ChannelItem{{Channel: "a"}, {Channel: "b"}}
+	err := intercept(context.Background(), []Interceptor{
+		func(ctx context.Context, channel string, item *Item,
+			next PublishFunc) error {
+			return next(shared, channel, item)
+		}}, items, sender.send, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, len(sender.batches[0]), 2)
+	assert.Equal(t, sender.ctxs[0], shared)
+
+	sender = &recordingSender{}
+	err = intercept(context.Background(), []Interceptor{
+		func(ctx context.Context, channel string, item *Item,
+			next PublishFunc) error {
+			return next(context.WithValue(ctx, testContextKey{}, channel),
+				channel, item)
+		}}, items, sender.send, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, len(sender.batches[0]), 2)
+	assert.Nil(t, sender.ctxs[0].Value(testContextKey{}))
+}
+
+func TestInterceptBatchDrop(t *testing.T) {
+	sender := &recordingSender{}
+	dropped := func(ctx context.Context, channel string, item *Item,
+		next PublishFunc) error {
+		if strings.HasPrefix(channel, "drop") {
+			return nil
+		}
+		return next(ctx, channel, item)
+	}
+	items := []ChannelItem{{Channel: "a"}, {Channel: "drop-b"},
+		{Channel: "c"}}
+	assert.Nil(t, intercept(context.Background(), []Interceptor{dropped},
+		items, sender.send, nil))
+	assert.Equal(t, sender.batches, [][]ChannelItem{{{Channel: "a"},
+		{Channel: "c"}}})
+	sender.batches = nil
+	assert.Nil(t, intercept(context.Background(), []Interceptor{dropped},
+		[]ChannelItem{{Channel: "drop"}}, sender.send, nil))
+	assert.Equal(t, len(sender.batches), 0)
+}
+
+func TestInterceptResult(t *testing.T) {
+	sender := &recordingSender{err: errors.New("send failed")}
+	results := make(chan error, 2)
+	observer := func(ctx context.Context, channel string, item *Item,
+		next PublishFunc) error {
+		err := next(ctx, channel, item)
+		results <- err
+		return err
+	}
+	err := intercept(context.Background(), []Interceptor{observer},
+		[]ChannelItem{{Channel: "a"}, {Channel: "b"}}, sender.send, nil)
+	assert.Equal(t, err, sender.err)
+	assert.Equal(t, <-results, sender.err)
+	assert.Equal(t, <-results, sender.err)
+	assert.Equal(t, len(sender.batches), 1)
+}
+
+func TestInterceptAbort(t *testing.T) {
+	sender := &recordingSender{}
+	rejected := errors.New("rejected")
+	err := intercept(context.Background(), []Interceptor{
+		func(ctx context.Context, channel string, item *Item,
+			next PublishFunc) error {
+			if channel == "bad" {
+				return rejected
+			}
+			return next(ctx, channel, item)
+		}}, []ChannelItem{{Channel: "good"}, {Channel: "bad"}}, sender.send, nil)
+	assert.Equal(t, err, rejected)
+	assert.Equal(t, len(sender.batches), 0)
+}
+
+func TestInterceptMisuse(t *testing.T) {
+	sender := &recordingSender{}
+	err := intercept(context.Background(), []Interceptor{
+		func(ctx context.Context, channel string, item *Item,
+			next PublishFunc) error {
+			next(ctx, channel, item)
+			return next(ctx, channel, item)
+		}}, []ChannelItem{{Channel: "a"}}, sender.send, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, len(sender.batches), 1)
+	err = intercept(context.Background(), []Interceptor{
+		func(ctx context.Context, channel string, item *Item,
+			next PublishFunc) error {
+			panic("Intentional panic for tests")
+		}}, []ChannelItem{{Channel: "a"}}, sender.send, nil)
+	assert.True(t, strings.Contains(err.Error(), "Intentional panic"))
+}
+
+func TestPcPublishInterceptors(t *testing.T) {
+	publishResults1 = nil
+	pc := NewPubControl(nil)
+	pcc := NewPubControlClient("uri")
+	pcc.publish = publish1
+	pcc.AddInterceptor(prefixInterceptor("client."))
+	pc.AddClient(pcc)
+	pc.AddInterceptor(prefixInterceptor("tenant."))
+	item := newPolicyTestItem()
+	assert.Nil(t, pc.Publish("chan", item))
+	assert.Equal(t, publishResults1, []interface{}{"client.tenant.chan", item})
+}
@@ -18,6 +18,14 @@ type Item struct {
 	formats []Formatter
 }
 
+// The ChannelItem struct pairs an Item instance with the channel that it
+// should be published to. A slice of ChannelItem instances is used to
+// publish several items in a single request.
+type ChannelItem struct {
+	Channel string
+	Item    *Item
+}
+
 // Initialize this struct with either a single Format implementation
 // instance or an array of Format implementation instances. Optionally
 // specify an ID and/or previous ID to be sent as part of the message
@@ -0,0 +1,144 @@
+//    listener.go
+//    ~~~~~~~~~
+//    This module implements the PublishListener functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"time"
+)
+
+// The PublishEvent struct describes the outcome of publishing a single
+// item to a single client. The client is nil if the item was dropped
+// before a client was chosen.
+type PublishEvent struct {
+	Channel  string
+	Item     *Item
+	Client   *PubControlClient
+	Start    time.Time
+	Duration time.Duration
+
+	// The error that caused the failure, retry or drop. It is an
+	// UnroutedError for items dropped because no client route matched
+	// their channel, and nil for successes and for items dropped by an
+	// interceptor.
+	Err error
+}
+
+// The PublishListener interface is used to react to the outcome of every
+// published item. The methods are called synchronously from the
+// publishing goroutines and must be safe for concurrent use.
+type PublishListener interface {
+
+	// Called when an item was accepted by a client's endpoint.
+	OnPublishSuccess(event PublishEvent)
+
+	// Called when a request containing the item was made to a client's
+	// endpoint and failed.
+	OnPublishFailure(event PublishEvent)
+
+	// Called when an item is about to be published to the client of the
+	// event after the previous client failed, such as with the failover
+	// policy. The error is the error of the previous client.
+	OnRetry(event PublishEvent)
+
+	// Called when an item was never sent to an endpoint, because an
+	// interceptor dropped it, no client route matched its channel, or the
+	// circuit breaker or rate limiter of the client refused it.
+	OnDrop(event PublishEvent)
+}
+
+// The BaseListener struct implements every PublishListener method as a
+// no-op. Embed it to implement only the methods of interest.
+type BaseListener struct{}
+
+func (BaseListener) OnPublishSuccess(event PublishEvent) {}
+func (BaseListener) OnPublishFailure(event PublishEvent) {}
+func (BaseListener) OnRetry(event PublishEvent)          {}
+func (BaseListener) OnDrop(event PublishEvent)           {}
+
+// Get a listener that calls the specified function with true and a nil
+// error for every successful publish, and with false and the error for
+// every failed or dropped publish. The error is nil for items dropped by
+// an interceptor.
+func CallbackListener(callback func(result bool, err error)) PublishListener {
+	return callbackListener{callback: callback}
+}
+
+// An internal struct used to implement CallbackListener.
+type callbackListener struct {
+	BaseListener
+	callback func(result bool, err error)
+}
+
+func (l callbackListener) OnPublishSuccess(event PublishEvent) {
+	l.callback(true, nil)
+}
+
+func (l callbackListener) OnPublishFailure(event PublishEvent) {
+	l.callback(false, event.Err)
+}
+
+func (l callbackListener) OnDrop(event PublishEvent) {
+	l.callback(false, event.Err)
+}
+
+// An internal type used as the context key for the listener of a
+// PubControl instance.
+type listenerContextKey struct{}
+
+// An internal function that returns a context carrying the specified
+// listener.
+func withListener(ctx context.Context,
+	listener PublishListener) context.Context {
+	if listener == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, listenerContextKey{}, listener)
+}
+
+// An internal function that returns the listener carried by the context
+// or nil if there is none.
+func listenerFromContext(ctx context.Context) PublishListener {
+	listener, _ := ctx.Value(listenerContextKey{}).(PublishListener)
+	return listener
+}
+
+// Set the listener that is notified of the outcome of every published
+// item. Set nil to remove the listener.
+func (pc *PubControl) SetListener(listener PublishListener) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.listener = listener
+}
+
+// An internal function that calls the specified listener method once for
+// every item.
+func notifyListener(notify func(event PublishEvent), client *PubControlClient,
+	items []ChannelItem, start time.Time, err error) {
+	duration := time.Since(start)
+	for _, entry := range items {
+		notify(PublishEvent{Channel: entry.Channel, Item: entry.Item,
+			Client: client, Start: start, Duration: duration, Err: err})
+	}
+}
+
+// An internal function that returns the function notifying the listener
+// carried by the context of items dropped before they reached the
+// specified client with the specified error, or nil if there is no
+// listener. The client and error may be nil.
+func dropNotifier(ctx context.Context, client *PubControlClient,
+	err error) func(entry ChannelItem) {
+	listener := listenerFromContext(ctx)
+	if listener == nil {
+		return nil
+	}
+	return func(entry ChannelItem) {
+		listener.OnDrop(PublishEvent{Channel: entry.Channel, Item: entry.Item,
+			Client: client, Start: time.Now(), Err: err})
+	}
+}
@@ -0,0 +1,169 @@
+//    listener_test.go
+//    ~~~~~~~~~
+//    This module implements the PublishListener tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testListener struct {
+	lock      sync.Mutex
+	successes []PublishEvent
+	failures  []PublishEvent
+	retries   []PublishEvent
+	drops     []PublishEvent
+}
+
+func (l *testListener) OnPublishSuccess(event PublishEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.successes = append(l.successes, event)
+}
+
+func (l *testListener) OnPublishFailure(event PublishEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.failures = append(l.failures, event)
+}
+
+func (l *testListener) OnRetry(event PublishEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.retries = append(l.retries, event)
+}
+
+func (l *testListener) OnDrop(event PublishEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.drops = append(l.drops, event)
+}
+
+func TestPcListenerSuccess(t *testing.T) {
+	listener := &testListener{}
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	pc.SetListener(listener)
+	item := newPolicyTestItem()
+	assert.Nil(t, pc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: item}, {Channel: "b", Item: item}}))
+	assert.Equal(t, len(listener.successes), 2)
+	event := listener.successes[1]
+	assert.Equal(t, event.Channel, "b")
+	assert.Equal(t, event.Item, item)
+	assert.Equal(t, event.Client, pc.clients[0])
+	assert.Nil(t, event.Err)
+	assert.False(t, event.Start.IsZero())
+	assert.True(t, event.Duration >= time.Millisecond)
+}
+
+func TestPcListenerFailover(t *testing.T) {
+	listener := &testListener{}
+	failed := errors.New("failed")
+	pc := newPolicyTestPubControl(
+		func(pcc *PubControlClient, ctx context.Context,
+			items []ChannelItem) error {
+			return failed
+		},
+		func(pcc *PubControlClient, ctx context.Context,
+			items []ChannelItem) error {
+			return nil
+		})
+	pc.SetPublishPolicy(PolicyFailover())
+	pc.SetListener(listener)
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, len(listener.failures), 1)
+	assert.Equal(t, listener.failures[0].Client, pc.clients[0])
+	assert.Equal(t, listener.failures[0].Err, failed)
+	assert.Equal(t, len(listener.retries), 1)
+	assert.Equal(t, listener.retries[0].Client, pc.clients[1])
+	assert.Equal(t, listener.retries[0].Err, failed)
+	assert.Equal(t, len(listener.successes), 1)
+	assert.Equal(t, listener.successes[0].Client, pc.clients[1])
+}
+
+func TestPcListenerDrop(t *testing.T) {
+	listener := &testListener{}
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		return nil
+	})
+	pc.SetClientRoute(pc.clients[0], &Route{Include: []ChannelFilter{
+		ChannelPrefix("routed.")}})
+	pc.AddInterceptor(func(ctx context.Context, channel string, item *Item,
+		next PublishFunc) error {
+		if channel == "dropped" {
+			return nil
+		}
+		return next(ctx, channel, item)
+	})
+	pc.SetListener(listener)
+	assert.Nil(t, pc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "routed.a", Item: newPolicyTestItem()},
+		{Channel: "dropped", Item: newPolicyTestItem()},
+		{Channel: "unrouted", Item: newPolicyTestItem()}}))
+	assert.Equal(t, len(listener.successes), 1)
+	assert.Equal(t, len(listener.drops), 2)
+	channels := []string{listener.drops[0].Channel, listener.drops[1].Channel}
+	assert.ElementsMatch(t, channels, []string{"dropped", "unrouted"})
+	assert.Nil(t, listener.drops[0].Client)
+	for _, event := range listener.drops {
+		_, ok := event.Err.(*UnroutedError)
+		assert.Equal(t, ok, event.Channel == "unrouted")
+	}
+
+	var errs []error
+	pc.SetListener(CallbackListener(func(result bool, err error) {
+		assert.False(t, result)
+		errs = append(errs, err)
+	}))
+	assert.Nil(t, pc.Publish("unrouted", newPolicyTestItem()))
+	assert.Equal(t, len(errs), 1)
+	assert.NotNil(t, errs[0])
+	assert.Equal(t, errs[0].Error(), "No client route matched the channel")
+}
+
+func TestPcListenerCircuitOpen(t *testing.T) {
+	var results []bool
+	var errs []error
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		return &PublishError{err: "error"}
+	})
+	pc.clients[0].SetCircuitBreaker(NewCircuitBreaker(CircuitBreakerConfig{
+		FailureThreshold: 1}))
+	pc.SetListener(CallbackListener(func(result bool, err error) {
+		results = append(results, result)
+		errs = append(errs, err)
+	}))
+	assert.NotNil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.NotNil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, results, []bool{false, false})
+	_, ok := errs[0].(*PublishError)
+	assert.True(t, ok)
+	_, ok = errs[1].(*CircuitOpenError)
+	assert.True(t, ok)
+}
+
+func TestPcListenerPanic(t *testing.T) {
+	listener := &testListener{}
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		panic("Intentional panic for tests")
+	})
+	pc.SetListener(listener)
+	assert.NotNil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, len(listener.failures), 1)
+}
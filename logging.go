@@ -0,0 +1,200 @@
+//    logging.go
+//    ~~~~~~~~~
+//    This module implements the structured logging functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The value that replaces secrets in log records.
+const redacted = "[REDACTED]"
+
+// An internal type used as the context key for the logger of a PubControl
+// instance.
+type loggerContextKey struct{}
+
+// An internal function that returns a context carrying the specified
+// logger so that clients without a logger of their own use it.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	if logger == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, loggerContextKey{}, logger)
+}
+
+// An internal function that returns the logger carried by the context or
+// nil if there is none.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	logger, _ := ctx.Value(loggerContextKey{}).(*slog.Logger)
+	return logger
+}
+
+// Set the logger that publish attempts, retries, failures and
+// configuration changes are logged to. Clients without a logger of their
+// own use it as well. Set nil to disable logging.
+func (pc *PubControl) SetLogger(logger *slog.Logger) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.logger = logger
+}
+
+// An internal method that returns the logger of this instance.
+func (pc *PubControl) getLogger() *slog.Logger {
+	pc.clientsRWLock.RLock()
+	defer pc.clientsRWLock.RUnlock()
+	return pc.logger
+}
+
+// Set the logger that publish attempts and failures of this client are
+// logged to. If no logger is set then the logger of the PubControl
+// instance publishing through this client, if any, is used.
+func (pcc *PubControlClient) SetLogger(logger *slog.Logger) {
+	pcc.lock.Lock()
+	pcc.logger = logger
+	pcc.lock.Unlock()
+}
+
+// An internal method that returns the logger to use for a publish with
+// the specified context, or nil if there is none.
+func (pcc *PubControlClient) loggerFor(ctx context.Context) *slog.Logger {
+	pcc.lock.Lock()
+	logger := pcc.logger
+	pcc.lock.Unlock()
+	if logger == nil {
+		logger = loggerFromContext(ctx)
+	}
+	return logger
+}
+
+// Get the value used when logging this client. It contains the name, the
+// URI and the kind of authentication, but never any credentials.
+func (pcc *PubControlClient) LogValue() slog.Value {
+	identity := pcc.authIdentity()
+	attrs := make([]slog.Attr, 0, 3)
+	if name := pcc.Name(); name != "" {
+		attrs = append(attrs, slog.String("name", name))
+	}
+	attrs = append(attrs, slog.String("uri", RedactUri(pcc.Uri())))
+	if identity != "" {
+		attrs = append(attrs, slog.String("auth", identity))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// Get the specified URI with any password and the values of its query
+// parameters replaced, as query strings may carry keys, tokens or
+// signatures. URIs that cannot be parsed are returned unchanged.
+func RedactUri(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for _, values := range query {
+			for i, value := range values {
+				if value != "" {
+					values[i] = "xxxxx"
+				}
+			}
+		}
+		parsed.RawQuery = query.Encode()
+	}
+	return parsed.Redacted()
+}
+
+// An internal type used to log a configuration entry with its secrets
+// redacted.
+type configLogValue map[string]interface{}
+
+// Get the value used when logging the configuration entry. The keys are
+// sorted and the "key" entry and the values of the "headers" entry are
+// redacted.
+func (entry configLogValue) LogValue() slog.Value {
+	keys := make([]string, 0, len(entry))
+	for key := range entry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		value := entry[key]
+		switch {
+		case key == "key":
+			value = redacted
+		case key == "headers":
+			names := make([]string, 0)
+			for name := range headersFromConfig(value) {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			headers := make([]slog.Attr, 0, len(names))
+			for _, name := range names {
+				headers = append(headers, slog.String(name, redacted))
+			}
+			value = slog.GroupValue(headers...)
+		case key == "uri":
+			if uri, ok := value.(string); ok {
+				value = RedactUri(uri)
+			}
+		}
+		attrs = append(attrs, slog.Any(key, value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// An internal function that logs the result of applying a configuration.
+func logConfigChanges(logger *slog.Logger, source string,
+	changes ConfigChanges) {
+	if logger == nil || !changes.Changed() {
+		return
+	}
+	logger.Info("Applied publishing configuration",
+		slog.String("source", source),
+		slog.Int("added", len(changes.Added)),
+		slog.Int("removed", len(changes.Removed)),
+		slog.Int("updated", len(changes.Updated)))
+}
+
+// An internal function that logs a panic recovered while publishing.
+func logPanic(logger *slog.Logger, client *PubControlClient,
+	err interface{}, stack []byte) {
+	if logger == nil {
+		return
+	}
+	logger.Error("Recovered panic while publishing",
+		slog.Any("client", client), slog.Any("panic", err),
+		slog.String("stack", strings.TrimSpace(string(stack))))
+}
+
+// An internal function that logs the result of a publish to a client.
+// Failures are logged as warnings and successes at the debug level.
+func logPublish(ctx context.Context, logger *slog.Logger,
+	client *PubControlClient, items []ChannelItem, duration time.Duration,
+	err error) {
+	if logger == nil {
+		return
+	}
+	attrs := []slog.Attr{slog.Any("client", client),
+		slog.String("channel", channelNames(items)),
+		slog.Int("items", len(items)), slog.Duration("duration", duration)}
+	if err == nil {
+		logger.LogAttrs(ctx, slog.LevelDebug, "Published items", attrs...)
+		return
+	}
+	if publishErr, ok := err.(*PublishError); ok && publishErr.statusCode != 0 {
+		attrs = append(attrs, slog.Int("status_code", publishErr.statusCode))
+	}
+	attrs = append(attrs, slog.String("error", err.Error()))
+	logger.LogAttrs(ctx, slog.LevelWarn, "Failed to publish items", attrs...)
+}
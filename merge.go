@@ -0,0 +1,145 @@
+//    merge.go
+//    ~~~~~~~~~
+//    This module implements merging of configurations into PubControl.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Enable or disable merge mode. While merge mode is enabled the
+// ApplyConfig method behaves like the ApplyConfigMerge method instead of
+// always adding new clients.
+func (pc *PubControl) SetMergeConfig(merge bool) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.mergeConfig = merge
+}
+
+// Apply the specified configuration to this PubControl instance without
+// duplicating clients. Each entry is keyed by its normalized URI and the
+// identity it authenticates as, which is the issuer for JWT
+// authentication, so that different keys for the same issuer are treated
+// as an update. If a configured client has the same key then it is updated
+// in place; otherwise a new client is added. Clients that are not
+// mentioned in the configuration are left untouched. The returned changes
+// contain the added and updated clients.
+func (pc *PubControl) ApplyConfigMerge(
+	config []map[string]interface{}) ConfigChanges {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	return pc.applyConfigMerge(config)
+}
+
+// An internal method that merges the specified configuration. The write
+// lock must be held by the caller.
+func (pc *PubControl) applyConfigMerge(
+	config []map[string]interface{}) ConfigChanges {
+	changes := ConfigChanges{}
+	existing := make(map[string]*PubControlClient)
+	for _, pcc := range pc.clients {
+		key := identityKey(pcc.Uri(), pcc.authIdentity())
+		if _, ok := existing[key]; !ok {
+			existing[key] = pcc
+		}
+	}
+	added := make(map[*PubControlClient]bool)
+	updated := make(map[*PubControlClient]bool)
+	for _, entry := range config {
+		uri, ok := entry["uri"].(string)
+		if !ok {
+			continue
+		}
+		key := identityKey(uri, entryIdentity(entry))
+		pcc, ok := existing[key]
+		if !ok {
+			pcc = clientFromConfig(entry)
+			pc.clients = append(pc.clients, pcc)
+			existing[key] = pcc
+			added[pcc] = true
+			changes.Added = append(changes.Added, pcc)
+		} else if reflect.DeepEqual(pc.entries[pcc], entry) {
+			continue
+		} else {
+			configureClientAuth(pcc, entry)
+			if !added[pcc] && !updated[pcc] {
+				updated[pcc] = true
+				changes.Updated = append(changes.Updated, pcc)
+			}
+		}
+		pc.configureClient(pcc, entry)
+	}
+	pc.updateRing()
+	logConfigChanges(pc.logger, "merge", changes)
+	return changes
+}
+
+// An internal method that returns the identity this client authenticates
+// as. See entryIdentity.
+func (pcc *PubControlClient) authIdentity() string {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	if pcc.authBasicUser != "" {
+		return "basic:" + pcc.authBasicUser
+	} else if pcc.authJwtClaim != nil {
+		return fmt.Sprintf("jwt:%v", pcc.authJwtClaim["iss"])
+	} else if pcc.authBearerKey != "" {
+		return "bearer"
+	}
+	return ""
+}
+
+// An internal function that returns the identity a configuration entry
+// authenticates as: the issuer for JWT authentication, a constant for
+// bearer authentication and an empty string if there is no authentication.
+// Secrets are not part of the identity.
+func entryIdentity(entry map[string]interface{}) string {
+	if iss, ok := entry["iss"]; ok {
+		return fmt.Sprintf("jwt:%v", iss)
+	} else if _, ok := entry["key"]; ok {
+		return "bearer"
+	}
+	return ""
+}
+
+// An internal function that combines a normalized URI and an
+// authentication identity into a key identifying a client.
+func identityKey(uri, identity string) string {
+	return NormalizeUri(uri) + " " + identity
+}
+
+// Normalize an endpoint URI so that equivalent URIs compare equal. The
+// scheme and host are lowercased, default ports are removed and trailing
+// slashes are removed from the path. URIs that cannot be parsed are
+// returned unchanged.
+func NormalizeUri(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return uri
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") ||
+		(u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host = host + ":" + port
+	}
+	u.Host = host
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	return u.String()
+}
@@ -0,0 +1,144 @@
+//    merge_test.go
+//    ~~~~~~~~~
+//    This module implements the configuration merging tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNormalizeUri(t *testing.T) {
+	assert.Equal(t, NormalizeUri("HTTP://Example.COM:80/realm/"),
+		"http://example.com/realm")
+	assert.Equal(t, NormalizeUri("https://example.com:443"),
+		"https://example.com")
+	assert.Equal(t, NormalizeUri("https://example.com:8443/a//?x=1#f"),
+		"https://example.com:8443/a?x=1")
+	assert.Equal(t, NormalizeUri("http://[::1]:80/"), "http://[::1]")
+	assert.Equal(t, NormalizeUri("uri"), "uri")
+}
+
+func TestEntryIdentity(t *testing.T) {
+	assert.Equal(t, entryIdentity(map[string]interface{}{"uri": "uri"}), "")
+	assert.Equal(t, entryIdentity(map[string]interface{}{"uri": "uri",
+		"key": "key"}), "bearer")
+	assert.Equal(t, entryIdentity(map[string]interface{}{"uri": "uri",
+		"iss": "realm", "key": "key"}), "jwt:realm")
+	pcc := NewPubControlClient("uri")
+	assert.Equal(t, pcc.authIdentity(), "")
+	pcc.SetAuthBearer("key")
+	assert.Equal(t, pcc.authIdentity(), "bearer")
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "realm"}, []byte("key"))
+	assert.Equal(t, pcc.authIdentity(), "jwt:realm")
+	pcc.SetAuthBasic("user", "pass")
+	assert.Equal(t, pcc.authIdentity(), "basic:user")
+}
+
+func TestPcApplyConfigMerge(t *testing.T) {
+	pc := NewPubControl(nil)
+	changes := pc.ApplyConfigMerge([]map[string]interface{}{
+		map[string]interface{}{"uri": "http://example.com/realm",
+			"iss": "realm", "key": "key"},
+		map[string]interface{}{"uri": "http://EXAMPLE.com/realm/",
+			"iss": "realm", "key": "key"},
+		map[string]interface{}{"uri": "http://example.com/realm",
+			"iss": "other", "key": "key"}})
+	assert.Equal(t, len(changes.Added), 2)
+	assert.Equal(t, len(changes.Updated), 0)
+	assert.Equal(t, len(pc.clients), 2)
+	first := pc.clients[0]
+
+	changes = pc.ApplyConfigMerge([]map[string]interface{}{
+		map[string]interface{}{"uri": "http://example.com:80/realm",
+			"iss": "realm", "key": "key2", "name": "main"},
+		map[string]interface{}{"uri": "http://example.com/realm",
+			"iss": "other", "key": "key"},
+		map[string]interface{}{"uri": "http://example.com/other"}})
+	assert.Equal(t, changes.Added, []*PubControlClient{pc.clients[2]})
+	assert.Equal(t, changes.Updated, []*PubControlClient{first})
+	assert.Equal(t, len(changes.Removed), 0)
+	assert.Equal(t, len(pc.clients), 3)
+	assert.Equal(t, pc.clients[0], first)
+	assert.Equal(t, first.authJwtKey, []byte("key2"))
+	assert.Equal(t, first.Name(), "main")
+	assert.Equal(t, first.uri, "http://example.com/realm")
+}
+
+func TestPcApplyConfigMergeManualClient(t *testing.T) {
+	pc := NewPubControl(nil)
+	pcc := NewPubControlClient("http://example.com/")
+	pcc.SetAuthBearer("old")
+	pc.AddClient(pcc)
+	pc.SetMergeConfig(true)
+	pc.ApplyConfig([]map[string]interface{}{
+		map[string]interface{}{"uri": "http://example.com",
+			"key": "new", "include": "a.*"}})
+	pc.ApplyConfig([]map[string]interface{}{
+		map[string]interface{}{"uri": "http://example.com",
+			"key": "new", "include": "a.*"}})
+	assert.Equal(t, pc.clients, []*PubControlClient{pcc})
+	assert.Equal(t, pcc.authBearerKey, "new")
+	assert.NotNil(t, pc.routes[pcc])
+	pc.SetMergeConfig(false)
+	pc.ApplyConfig([]map[string]interface{}{
+		map[string]interface{}{"uri": "http://example.com"}})
+	assert.Equal(t, len(pc.clients), 2)
+}
+
+func TestPcApplyConfigMergeConcurrentAuth(t *testing.T) {
+	pc := NewPubControl(nil)
+	pc.SetMergeConfig(true)
+	configs := [][]map[string]interface{}{
+		{{"uri": "uri", "iss": "realm", "key": "key1"}},
+		{{"uri": "uri", "iss": "realm", "key": "key2"}}}
+	pc.ApplyConfig(configs[0])
+	pcc := pc.clients[0]
+	var lock sync.Mutex
+	headers := make([]string, 0)
+	pcc.pubCall = func(pcc *PubControlClient, ctx context.Context, uri,
+		authHeader string, items []map[string]interface{}) error {
+		lock.Lock()
+		headers = append(headers, authHeader)
+		lock.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					assert.Nil(t, pcc.Publish("chan", item))
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5000; i++ {
+		pc.ApplyConfig(configs[i%2])
+	}
+	close(done)
+	wg.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	invalid := 0
+	for _, header := range headers {
+		if !strings.HasPrefix(header, "Bearer ") {
+			invalid++
+		}
+	}
+	assert.Equal(t, invalid, 0)
+}
@@ -0,0 +1,69 @@
+//    metrics.go
+//    ~~~~~~~~~
+//    This module implements the MetricsCollector interface.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"time"
+)
+
+// The MetricsCollector interface is used to record metrics about publish
+// traffic. Clients are identified by their name, or their URI if they have
+// no name. Implementations must be safe for concurrent use. The
+// pubcontrolprom package contains an implementation for Prometheus.
+type MetricsCollector interface {
+
+	// Called when a client has finished publishing a batch of items,
+	// whether or not a request was made. The error is nil on success.
+	PublishCompleted(client string, items int, duration time.Duration,
+		err error)
+
+	// Called when an HTTP request to a publishing endpoint has completed.
+	// The status code is zero if no response was received.
+	RequestCompleted(client string, statusCode int, bytesSent int,
+		duration time.Duration, err error)
+
+	// Called when the number of publishes waiting for the rate limiter of
+	// a client has changed.
+	QueueDepthChanged(client string, depth int)
+
+	// Called when a publish is retried on a client after an earlier
+	// attempt failed, such as when moving to the next client with the
+	// failover policy.
+	Retried(client string)
+}
+
+// An internal type used as the context key for the MetricsCollector
+// instance of a PubControl instance.
+type metricsContextKey struct{}
+
+// An internal function that returns a context carrying the specified
+// metrics collector so that clients without a collector of their own
+// report to it.
+func withMetrics(ctx context.Context, metrics MetricsCollector) context.Context {
+	if metrics == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, metricsContextKey{}, metrics)
+}
+
+// An internal function that returns the metrics collector carried by the
+// context or nil if there is none.
+func metricsFromContext(ctx context.Context) MetricsCollector {
+	metrics, _ := ctx.Value(metricsContextKey{}).(MetricsCollector)
+	return metrics
+}
+
+// Get the class of an HTTP status code, such as "2xx", or "error" if no
+// response was received.
+func StatusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return string(rune('0'+statusCode/100)) + "xx"
+}
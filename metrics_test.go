@@ -0,0 +1,163 @@
+//    metrics_test.go
+//    ~~~~~~~~~
+//    This module implements the MetricsCollector tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	lock        sync.Mutex
+	publishes   []string
+	items       []int
+	errs        []error
+	statusCodes []int
+	bytesSent   []int
+	depths      []int
+	retries     []string
+}
+
+func (m *testMetrics) PublishCompleted(client string, items int,
+	duration time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.publishes = append(m.publishes, client)
+	m.items = append(m.items, items)
+	m.errs = append(m.errs, err)
+}
+
+func (m *testMetrics) RequestCompleted(client string, statusCode int,
+	bytesSent int, duration time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.statusCodes = append(m.statusCodes, statusCode)
+	m.bytesSent = append(m.bytesSent, bytesSent)
+}
+
+func (m *testMetrics) QueueDepthChanged(client string, depth int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.depths = append(m.depths, depth)
+}
+
+func (m *testMetrics) Retried(client string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.retries = append(m.retries, client)
+}
+
+func TestStatusClass(t *testing.T) {
+	assert.Equal(t, StatusClass(200), "2xx")
+	assert.Equal(t, StatusClass(429), "4xx")
+	assert.Equal(t, StatusClass(503), "5xx")
+	assert.Equal(t, StatusClass(0), "error")
+	assert.Equal(t, StatusClass(600), "error")
+}
+
+func TestPccMetricsRequest(t *testing.T) {
+	metrics := &testMetrics{}
+	pcc := NewPubControlClient("uri")
+	pcc.SetName("main")
+	pcc.SetMetrics(metrics)
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		return 503, []byte("unavailable"), nil
+	}
+	err := pcc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: newPolicyTestItem()},
+		{Channel: "b", Item: newPolicyTestItem()}})
+	assert.NotNil(t, err)
+	assert.Equal(t, err.(*PublishError).StatusCode(), 503)
+	assert.Equal(t, metrics.publishes, []string{"main"})
+	assert.Equal(t, metrics.items, []int{2})
+	assert.Equal(t, metrics.errs, []error{err})
+	assert.Equal(t, metrics.statusCodes, []int{503})
+	assert.True(t, metrics.bytesSent[0] > 0)
+}
+
+func TestPccMetricsPanic(t *testing.T) {
+	metrics := &testMetrics{}
+	logger, buf := newTestLogger()
+	pcc := NewPubControlClient("uri")
+	pcc.SetMetrics(metrics)
+	pcc.SetLogger(logger)
+	pcc.publish = func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		panic("Intentional panic for tests")
+	}
+	assert.Panics(t, func() {
+		pcc.Publish("chan", newPolicyTestItem())
+	})
+	assert.Equal(t, len(metrics.errs), 1)
+	assert.NotNil(t, metrics.errs[0])
+	assert.Equal(t, metrics.errs[0].Error(),
+		"PANIC: Intentional panic for tests")
+	assert.True(t, strings.Contains(buf.String(),
+		`level=WARN msg="Failed to publish items"`))
+	assert.False(t, strings.Contains(buf.String(), `msg="Published items"`))
+}
+
+func TestPccMetricsQueueDepth(t *testing.T) {
+	metrics := &testMetrics{}
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	pcc.SetMetrics(metrics)
+	pcc.SetRateLimiter(NewRateLimiter(RateLimitConfig{MaxInFlight: 1}))
+	release, err := pcc.RateLimiter().acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	go func() {
+		for pcc.RateLimiter().QueueDepth() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		release()
+	}()
+	assert.Nil(t, pcc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, metrics.depths, []int{1, 0})
+}
+
+func TestPcMetricsFailover(t *testing.T) {
+	metrics := &testMetrics{}
+	failed := errors.New("failed")
+	pc := newPolicyTestPubControl(
+		func(pcc *PubControlClient, ctx context.Context,
+			items []ChannelItem) error {
+			return failed
+		},
+		func(pcc *PubControlClient, ctx context.Context,
+			items []ChannelItem) error {
+			return nil
+		})
+	pc.clients[1].SetName("backup")
+	pc.SetPublishPolicy(PolicyFailover())
+	pc.SetMetrics(metrics)
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, metrics.publishes, []string{pc.clients[0].Uri(),
+		"backup"})
+	assert.Equal(t, metrics.errs, []error{failed, nil})
+	assert.Equal(t, metrics.retries, []string{"backup"})
+}
+
+func TestPcMetricsClientOverride(t *testing.T) {
+	pcMetrics := &testMetrics{}
+	pccMetrics := &testMetrics{}
+	pc := newPolicyTestPubControl(func(pcc *PubControlClient,
+		ctx context.Context, items []ChannelItem) error {
+		return nil
+	})
+	pc.clients[0].SetMetrics(pccMetrics)
+	pc.SetMetrics(pcMetrics)
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, len(pcMetrics.publishes), 0)
+	assert.Equal(t, len(pccMetrics.publishes), 1)
+}
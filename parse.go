@@ -0,0 +1,267 @@
+//    parse.go
+//    ~~~~~~~~~
+//    This module implements the publish request parsing functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The names of the formats validated by ParsePublishRequest along with
+// the name of their content field.
+var contentFields = map[string]string{
+	"http-response": "body",
+	"http-stream":   "content",
+	"ws-message":    "content",
+}
+
+// The ParsedFormat struct represents a format of a parsed item. Raw holds
+// the JSON value exactly as received. For the http-response, http-stream
+// and ws-message formats, Fields holds the decoded fields with numbers as
+// json.Number and Content the decoded body or content, whether it was
+// sent as text or base64 in the '-bin' field. Both are nil for other
+// formats, such as json-object or custom formats.
+type ParsedFormat struct {
+	Name    string
+	Raw     json.RawMessage
+	Fields  map[string]interface{}
+	Content []byte
+}
+
+// The ParsedItem struct represents an item of a publish request.
+type ParsedItem struct {
+	Channel string
+	Id      string
+	PrevId  string
+	Formats []ParsedFormat
+}
+
+// Get the format with the specified name or nil if the item does not
+// contain it.
+func (pi *ParsedItem) Format(name string) *ParsedFormat {
+	for i := range pi.Formats {
+		if pi.Formats[i].Name == name {
+			return &pi.Formats[i]
+		}
+	}
+	return nil
+}
+
+// Get an Item that publishes the formats of this item exactly as they
+// were received.
+func (pi *ParsedItem) Item() *Item {
+	formats := make([]Formatter, 0, len(pi.Formats))
+	for _, format := range pi.Formats {
+		formats = append(formats, rawFormatter{name: format.Name,
+			raw: format.Raw})
+	}
+	return NewItem(formats, pi.Id, pi.PrevId)
+}
+
+// An internal struct used to re-publish a format as it was received.
+type rawFormatter struct {
+	name string
+	raw  json.RawMessage
+}
+
+func (f rawFormatter) Name() string {
+	return f.name
+}
+
+func (f rawFormatter) Export() interface{} {
+	return f.raw
+}
+
+// Decode and validate the body of an EPCP publish request, which is a
+// JSON object with an array of items. Every item must have a channel and
+// at least one format, and must not contain duplicate keys. The
+// http-response, http-stream and ws-message formats must be objects that
+// do not contain both the text and the '-bin' variant of their content,
+// and whose '-bin' fields are valid base64. Other formats, such as
+// json-object or custom formats, are kept as they were received without
+// validation. A PublishRequestError is returned if validation fails.
+func ParsePublishRequest(r io.Reader) ([]ParsedItem, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	top, err := decodeObject(body)
+	if err != nil {
+		return nil, requestError("Invalid request: %s", err)
+	}
+	rawItems, ok := top["items"]
+	if !ok {
+		return nil, requestError("Invalid request: missing items")
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(rawItems, &items); err != nil || items == nil {
+		return nil, requestError("Invalid request: items must be an array")
+	}
+	parsed := make([]ParsedItem, 0, len(items))
+	for i, raw := range items {
+		item, err := parseItem(raw)
+		if err != nil {
+			return nil, requestError("Invalid item %d: %s", i, err)
+		}
+		parsed = append(parsed, item)
+	}
+	return parsed, nil
+}
+
+// An internal function that decodes and validates a single item.
+func parseItem(raw json.RawMessage) (ParsedItem, error) {
+	var item ParsedItem
+	fields, err := decodeObject(raw)
+	if err != nil {
+		return item, err
+	}
+	for _, key := range sortedKeys(fields) {
+		value := fields[key]
+		switch key {
+		case "channel":
+			err = json.Unmarshal(value, &item.Channel)
+		case "id":
+			err = json.Unmarshal(value, &item.Id)
+		case "prev-id":
+			err = json.Unmarshal(value, &item.PrevId)
+		default:
+			var format ParsedFormat
+			format, err = parseFormat(key, value)
+			item.Formats = append(item.Formats, format)
+		}
+		if err != nil {
+			return item, fmt.Errorf("%s: %s", key, err)
+		}
+	}
+	if item.Channel == "" {
+		return item, fmt.Errorf("missing channel")
+	}
+	if len(item.Formats) == 0 {
+		return item, fmt.Errorf("no formats")
+	}
+	return item, nil
+}
+
+// An internal function that decodes and validates a format.
+func parseFormat(name string, raw json.RawMessage) (ParsedFormat, error) {
+	format := ParsedFormat{Name: name, Raw: raw}
+	contentField, ok := contentFields[name]
+	if !ok {
+		return format, nil
+	}
+	if _, err := decodeObject(raw); err != nil {
+		return format, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&format.Fields); err != nil {
+		return format, err
+	}
+	for key, value := range format.Fields {
+		if !strings.HasSuffix(key, "-bin") {
+			continue
+		}
+		encoded, ok := value.(string)
+		if !ok {
+			return format, fmt.Errorf("%s must be a string", key)
+		}
+		if _, err := base64.StdEncoding.DecodeString(encoded); err != nil {
+			return format, fmt.Errorf("%s is not valid base64", key)
+		}
+	}
+	text, hasText := format.Fields[contentField]
+	binary, hasBinary := format.Fields[contentField+"-bin"]
+	switch {
+	case hasText && hasBinary:
+		return format, fmt.Errorf("both %s and %s-bin are set", contentField,
+			contentField)
+	case hasText:
+		content, ok := text.(string)
+		if !ok {
+			return format, fmt.Errorf("%s must be a string", contentField)
+		}
+		format.Content = []byte(content)
+	case hasBinary:
+		format.Content, _ = base64.StdEncoding.DecodeString(binary.(string))
+	}
+	if code, ok := format.Fields["code"]; ok && name == "http-response" {
+		number, ok := code.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			return format, fmt.Errorf("code must be an integer")
+		}
+	}
+	return format, nil
+}
+
+// An internal function that decodes a JSON object into its raw values,
+// failing on duplicate keys.
+func decodeObject(raw []byte) (map[string]json.RawMessage, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '{' {
+		return nil, fmt.Errorf("expected an object")
+	}
+	fields := make(map[string]json.RawMessage)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := token.(string)
+		if _, ok := fields[key]; ok {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after object")
+	}
+	return fields, nil
+}
+
+// An internal function that returns the keys of the specified object in
+// sorted order so that validation errors are deterministic.
+func sortedKeys(fields map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// An internal function that creates a PublishRequestError.
+func requestError(format string, args ...interface{}) error {
+	return &PublishRequestError{err: fmt.Sprintf(format, args...)}
+}
+
+// An error struct used to represent an invalid publish request.
+type PublishRequestError struct {
+	err string
+}
+
+// This function returns the message associated with the
+// PublishRequestError error struct.
+func (e PublishRequestError) Error() string {
+	return e.err
+}
@@ -0,0 +1,99 @@
+//    parse_test.go
+//    ~~~~~~~~~
+//    This module implements the publish request parsing tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestParsePublishRequest(t *testing.T) {
+	items, err := ParsePublishRequest(strings.NewReader(`{"items": [
+		{"channel": "a", "id": "2", "prev-id": "1",
+			"http-response": {"code": 200, "body": "hello"},
+			"http-stream": {"content-bin": "AAE="}},
+		{"channel": "b", "ws-message": {"content": "x", "n": 12345678901}}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(items), 2)
+	assert.Equal(t, items[0].Channel, "a")
+	assert.Equal(t, items[0].Id, "2")
+	assert.Equal(t, items[0].PrevId, "1")
+	assert.Equal(t, len(items[0].Formats), 2)
+	response := items[0].Format("http-response")
+	assert.Equal(t, response.Content, []byte("hello"))
+	assert.Equal(t, response.Fields["code"], json.Number("200"))
+	assert.Equal(t, items[0].Format("http-stream").Content, []byte{0, 1})
+	assert.Nil(t, items[0].Format("ws-message"))
+	assert.Equal(t, string(items[1].Format("ws-message").Raw),
+		`{"content": "x", "n": 12345678901}`)
+}
+
+func TestParsedItemRepublish(t *testing.T) {
+	items, err := ParsePublishRequest(strings.NewReader(
+		`{"items": [{"channel": "a", "id": "1", ` +
+			`"ws-message": {"content": "x", "n": 12345678901}}]}`))
+	assert.Nil(t, err)
+	export, err := items[0].Item().Export()
+	assert.Nil(t, err)
+	encoded, err := json.Marshal(export)
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded),
+		`{"id":"1","ws-message":{"content":"x","n":12345678901}}`)
+}
+
+func TestParsePublishRequestOtherFormats(t *testing.T) {
+	items, err := ParsePublishRequest(strings.NewReader(`{"items": [
+		{"channel": "a", "json-object": {"x": 1}, "custom": "value",
+			"ws-message": {"content": "x"}}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(items[0].Formats), 3)
+	object := items[0].Format("json-object")
+	assert.Equal(t, string(object.Raw), `{"x": 1}`)
+	assert.Nil(t, object.Fields)
+	assert.Nil(t, object.Content)
+	assert.Equal(t, string(items[0].Format("custom").Raw), `"value"`)
+	export, err := items[0].Item().Export()
+	assert.Nil(t, err)
+	encoded, err := json.Marshal(export)
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded),
+		`{"custom":"value","json-object":{"x":1},"ws-message":{"content":"x"}}`)
+}
+
+func TestParsePublishRequestInvalid(t *testing.T) {
+	for body, message := range map[string]string{
+		`[]`:                              "expected an object",
+		`{"items": {}`:                    "Invalid request",
+		`{}`:                              "missing items",
+		`{"items": {}}`:                   "items must be an array",
+		`{"items": [{"ws-message": {}}]}`: "missing channel",
+		`{"items": [{"channel": 1, "ws-message": {}}]}`:                                    "channel",
+		`{"items": [{"channel": "a"}]}`:                                                    "no formats",
+		`{"items": [{"channel": "a", "ws-message": {}, "ws-message": {}}]}`:                "duplicate key",
+		`{"items": [{"channel": "a", "ws-message": "x"}]}`:                                 "expected an object",
+		`{"items": [{"channel": "a", "ws-message": {"content-bin": "!"}}]}`:                "not valid base64",
+		`{"items": [{"channel": "a", "ws-message": {"content-bin": 1}}]}`:                  "must be a string",
+		`{"items": [{"channel": "a", "ws-message": {"content": 1}}]}`:                      "must be a string",
+		`{"items": [{"channel": "a", "http-response": {"code": "200"}}]}`:                  "code must be an integer",
+		`{"items": [{"channel": "a", "http-stream": {"content": "", "content-bin": ""}}]}`: "both content and content-bin",
+		`{"items": []} {}`: "unexpected data",
+	} {
+		_, err := ParsePublishRequest(strings.NewReader(body))
+		_, ok := err.(*PublishRequestError)
+		assert.True(t, ok, body)
+		if err != nil {
+			assert.True(t, strings.Contains(err.Error(), message),
+				body+": "+err.Error())
+		}
+	}
+	items, err := ParsePublishRequest(strings.NewReader(`{"items": []}`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(items), 0)
+}
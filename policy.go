@@ -0,0 +1,142 @@
+//    policy.go
+//    ~~~~~~~~~
+//    This module implements the PublishPolicy functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"fmt"
+	"strings"
+)
+
+type policyMode int
+
+const (
+	policyAll policyMode = iota
+	policyAny
+	policyQuorum
+	policyFailover
+)
+
+// The PublishPolicy struct determines how a PubControl instance publishes
+// to its clients and when a publish is considered successful. Instances
+// are created with the PolicyAll, PolicyAny, PolicyQuorum and
+// PolicyFailover functions.
+type PublishPolicy struct {
+	mode            policyMode
+	quorum          int
+	cancelRemaining bool
+}
+
+// Publish to all clients in parallel and succeed only if every client
+// succeeded. This is the default policy.
+func PolicyAll() PublishPolicy {
+	return PublishPolicy{mode: policyAll}
+}
+
+// Publish to all clients in parallel and succeed as soon as one client
+// has succeeded.
+func PolicyAny() PublishPolicy {
+	return PublishPolicy{mode: policyAny}
+}
+
+// Publish to all clients in parallel and succeed as soon as n clients have
+// succeeded, or fail as soon as so many clients have failed that n
+// clients can no longer succeed. If n is zero or negative then a majority
+// of the clients is required. If n exceeds the number of clients an item
+// is published to then all of those clients are required.
+func PolicyQuorum(n int) PublishPolicy {
+	return PublishPolicy{mode: policyQuorum, quorum: n}
+}
+
+// Publish to one client at a time in the order that the clients were
+// added, moving on to the next client only if the previous one failed.
+func PolicyFailover() PublishPolicy {
+	return PublishPolicy{mode: policyFailover}
+}
+
+// Get a copy of this policy that cancels the context passed to clients
+// whose publish is still in flight once the publish has succeeded or
+// failed. By default those publishes are allowed to finish in the
+// background.
+func (p PublishPolicy) WithCancelRemaining() PublishPolicy {
+	p.cancelRemaining = true
+	return p
+}
+
+// An internal method that returns the number of successful clients
+// required for a publish to the specified number of clients to succeed.
+func (p PublishPolicy) required(clientCount int) int {
+	switch p.mode {
+	case policyAny, policyFailover:
+		if clientCount > 0 {
+			return 1
+		}
+	case policyQuorum:
+		required := clientCount/2 + 1
+		if p.quorum > 0 {
+			required = p.quorum
+		}
+		if required > clientCount {
+			return clientCount
+		}
+		return required
+	}
+	return clientCount
+}
+
+// This function returns the name of the policy.
+func (p PublishPolicy) String() string {
+	switch p.mode {
+	case policyAny:
+		return "any"
+	case policyQuorum:
+		return fmt.Sprintf("quorum(%d)", p.quorum)
+	case policyFailover:
+		return "failover"
+	}
+	return "all"
+}
+
+// The ClientError struct contains the error returned by a single client
+// during a publish by a PubControl instance.
+type ClientError struct {
+	Client *PubControlClient
+	Err    error
+}
+
+// This function returns the message associated with the ClientError
+// struct prefixed with the name of the client, or its URI if it has no
+// name.
+func (e ClientError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Client.label(),
+		strings.TrimSpace(e.Err.Error()))
+}
+
+// An error struct used to represent a publish by a PubControl instance
+// that did not succeed according to its PublishPolicy. It contains the
+// errors of all of the clients that failed.
+type PubControlError struct {
+	channels    string
+	clientCount int
+	errs        []ClientError
+}
+
+// Get the errors of the clients that failed.
+func (e PubControlError) Errors() []ClientError {
+	return e.errs
+}
+
+// This function returns the message associated with the PubControlError
+// error struct.
+func (e PubControlError) Error() string {
+	errs := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		errs = append(errs, err.Error())
+	}
+	return fmt.Sprintf("%d/%d client(s) failed to publish to channel: %s Errors: [%s]",
+		len(e.errs), e.clientCount, e.channels, strings.Join(errs, "],["))
+}
@@ -0,0 +1,198 @@
+//    policy_test.go
+//    ~~~~~~~~~
+//    This module implements the PublishPolicy tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyRequired(t *testing.T) {
+	assert.Equal(t, PolicyAll().required(3), 3)
+	assert.Equal(t, PolicyAny().required(3), 1)
+	assert.Equal(t, PolicyAny().required(0), 0)
+	assert.Equal(t, PolicyQuorum(2).required(3), 2)
+	assert.Equal(t, PolicyQuorum(0).required(4), 3)
+	assert.Equal(t, PolicyQuorum(3).required(2), 2)
+	assert.Equal(t, PolicyQuorum(0).required(0), 0)
+	assert.Equal(t, PolicyFailover().required(3), 1)
+	assert.Equal(t, PolicyQuorum(2).String(), "quorum(2)")
+	assert.True(t, PolicyAny().WithCancelRemaining().cancelRemaining)
+	assert.False(t, PolicyAny().cancelRemaining)
+}
+
+func newPolicyTestPubControl(publishers ...publisher) *PubControl {
+	pc := NewPubControl(nil)
+	for _, p := range publishers {
+		pcc := NewPubControlClient("uri")
+		pcc.publish = p
+		pc.AddClient(pcc)
+	}
+	return pc
+}
+
+// A publisher that succeeds without recording anything, so that publishes
+// still running after a policy returned do not race with other tests.
+func publishSuccess(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error {
+	return nil
+}
+
+// Wrap the specified publishers so that the returned wait group completes
+// once every one of them has returned.
+func trackPublishers(publishers ...publisher) ([]publisher,
+	*sync.WaitGroup) {
+	wg := new(sync.WaitGroup)
+	tracked := make([]publisher, len(publishers))
+	for i, p := range publishers {
+		wg.Add(1)
+		p := p
+		tracked[i] = func(pcc *PubControlClient, ctx context.Context,
+			items []ChannelItem) error {
+			defer wg.Done()
+			return p(pcc, ctx, items)
+		}
+	}
+	return tracked, wg
+}
+
+func newPolicyTestItem() *Item {
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	return NewItem(formats, "", "")
+}
+
+func TestPcPublishPolicyAny(t *testing.T) {
+	pc := newPolicyTestPubControl(publishError, publishSuccess, publishError)
+	pc.SetPublishPolicy(PolicyAny())
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	pc = newPolicyTestPubControl(publishError, publishError)
+	pc.SetPublishPolicy(PolicyAny())
+	err := pc.Publish("chan", newPolicyTestItem())
+	assert.NotNil(t, err)
+	assert.Equal(t, len(err.(*PubControlError).Errors()), 2)
+}
+
+func TestPcPublishPolicyQuorum(t *testing.T) {
+	publishers, wg := trackPublishers(publishError, publishSuccess,
+		publishSuccess)
+	pc := newPolicyTestPubControl(publishers...)
+	pc.SetPublishPolicy(PolicyQuorum(0))
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	wg.Wait()
+	publishers, wg = trackPublishers(publishError, publishSuccess,
+		publishError)
+	pc = newPolicyTestPubControl(publishers...)
+	pc.SetPublishPolicy(PolicyQuorum(0))
+	err := pc.Publish("chan", newPolicyTestItem())
+	wg.Wait()
+	assert.NotNil(t, err)
+	assert.Equal(t, "2/3 client(s) failed to publish to channel: chan "+
+		"Errors: [uri: Intentional error for tests],[uri: Intentional "+
+		"error for tests]", err.Error())
+}
+
+func TestPcPublishPolicyQuorumExceedsClients(t *testing.T) {
+	pc := newPolicyTestPubControl(publishSuccess, publishSuccess)
+	pc.SetPublishPolicy(PolicyQuorum(3))
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	pc = newPolicyTestPubControl(publishSuccess, publishError)
+	pc.SetPublishPolicy(PolicyQuorum(3))
+	err := pc.Publish("chan", newPolicyTestItem())
+	assert.NotNil(t, err)
+	assert.Equal(t, len(err.(*PubControlError).Errors()), 1)
+}
+
+func TestPcPublishPolicyQuorumFailsEarly(t *testing.T) {
+	cancelled := make(chan error, 1)
+	slow := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+		return ctx.Err()
+	}
+	pc := newPolicyTestPubControl(publishError, slow, publishError)
+	pc.SetPublishPolicy(PolicyQuorum(2).WithCancelRemaining())
+	start := time.Now()
+	err := pc.Publish("chan", newPolicyTestItem())
+	assert.NotNil(t, err)
+	assert.Equal(t, len(err.(*PubControlError).Errors()), 2)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, <-cancelled, context.Canceled)
+}
+
+func TestPcPublishPolicyAnyReturnsEarly(t *testing.T) {
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	slow := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		defer wg.Done()
+		<-release
+		return nil
+	}
+	pc := newPolicyTestPubControl(slow, publishSuccess)
+	pc.SetPublishPolicy(PolicyAny())
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	close(release)
+	wg.Wait()
+}
+
+func TestPcPublishPolicyAnyCancelRemaining(t *testing.T) {
+	cancelled := make(chan error, 1)
+	slow := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		select {
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+		return ctx.Err()
+	}
+	pc := newPolicyTestPubControl(slow, publishSuccess)
+	pc.SetPublishPolicy(PolicyAny().WithCancelRemaining())
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, <-cancelled, context.Canceled)
+}
+
+func TestPcPublishPolicyFailover(t *testing.T) {
+	calls := make([]int, 0)
+	failing := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		calls = append(calls, 1)
+		return errors.New("Intentional error for tests")
+	}
+	succeeding := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		calls = append(calls, 2)
+		return nil
+	}
+	pc := newPolicyTestPubControl(failing, succeeding, failing)
+	pc.SetPublishPolicy(PolicyFailover())
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, calls, []int{1, 2})
+	calls = calls[:0]
+	pc = newPolicyTestPubControl(failing, publishPanic)
+	pc.SetPublishPolicy(PolicyFailover())
+	err := pc.Publish("chan", newPolicyTestItem())
+	assert.NotNil(t, err)
+	assert.Equal(t, len(err.(*PubControlError).Errors()), 2)
+	assert.Equal(t, calls, []int{1})
+	pc = newPolicyTestPubControl()
+	pc.SetPublishPolicy(PolicyFailover())
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+}
@@ -8,10 +8,16 @@
 package pubcontrol
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 )
 
 // The PubControl struct allows a consumer to manage a set of publishing
@@ -22,6 +28,18 @@ import (
 type PubControl struct {
 	clients       []*PubControlClient
 	clientsRWLock sync.RWMutex
+	policy        PublishPolicy
+	routes        map[*PubControlClient]*Route
+	weights       map[*PubControlClient]int
+	virtualNodes  int
+	ring          *hashRing
+	entries       map[*PubControlClient]map[string]interface{}
+	mergeConfig   bool
+	interceptors  []Interceptor
+	metrics       MetricsCollector
+	tracer        trace.Tracer
+	logger        *slog.Logger
+	listener      PublishListener
 }
 
 // Initialize with or without a configuration. A configuration can be applied
@@ -29,17 +47,60 @@ type PubControl struct {
 func NewPubControl(config []map[string]interface{}) *PubControl {
 	pc := new(PubControl)
 	pc.clients = make([]*PubControlClient, 0)
+	pc.routes = make(map[*PubControlClient]*Route)
+	pc.weights = make(map[*PubControlClient]int)
+	pc.entries = make(map[*PubControlClient]map[string]interface{})
 	if config != nil && len(config) > 0 {
 		pc.ApplyConfig(config)
 	}
 	return pc
 }
 
+// Set the policy that determines how the configured PubControlClient
+// instances are published to and when a publish is considered successful.
+// The default policy is PolicyAll.
+func (pc *PubControl) SetPublishPolicy(policy PublishPolicy) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.policy = policy
+}
+
+// Add an interceptor that is called for every item published through this
+// PubControl instance before it is routed to the clients. Interceptors are
+// called in the order they were added, the first one being outermost.
+func (pc *PubControl) AddInterceptor(interceptor Interceptor) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.interceptors = append(pc.interceptors, interceptor)
+}
+
+// Set the collector that publish metrics are recorded to. The collector
+// is used for all clients that do not have a collector of their own.
+func (pc *PubControl) SetMetrics(metrics MetricsCollector) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.metrics = metrics
+}
+
+// Set the OpenTelemetry tracer provider used to create a span for every
+// publish, with a child span for every client. The provider is used for
+// all clients that do not have a provider of their own. Set nil to
+// disable tracing.
+func (pc *PubControl) SetTracerProvider(provider trace.TracerProvider) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.tracer = newTracer(provider)
+}
+
 // Remove all of the configured PubControlClient instances.
 func (pc *PubControl) RemoveAllClients() {
 	pc.clientsRWLock.Lock()
 	defer pc.clientsRWLock.Unlock()
 	pc.clients = make([]*PubControlClient, 0)
+	pc.routes = make(map[*PubControlClient]*Route)
+	pc.weights = make(map[*PubControlClient]int)
+	pc.entries = make(map[*PubControlClient]map[string]interface{})
+	pc.updateRing()
 }
 
 // Add the specified PubControlClient instance.
@@ -47,81 +108,663 @@ func (pc *PubControl) AddClient(pcc *PubControlClient) {
 	pc.clientsRWLock.Lock()
 	defer pc.clientsRWLock.Unlock()
 	pc.clients = append(pc.clients, pcc)
+	pc.updateRing()
+}
+
+// Remove the specified PubControlClient instance. False is returned if
+// the client was not configured.
+func (pc *PubControl) RemoveClient(pcc *PubControlClient) bool {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	clients := make([]*PubControlClient, 0, len(pc.clients))
+	for _, client := range pc.clients {
+		if client != pcc {
+			clients = append(clients, client)
+		}
+	}
+	if len(clients) == len(pc.clients) {
+		return false
+	}
+	pc.clients = clients
+	delete(pc.routes, pcc)
+	delete(pc.weights, pcc)
+	delete(pc.entries, pcc)
+	pc.updateRing()
+	return true
+}
+
+// Atomically replace all of the configured PubControlClient instances with
+// the specified clients. Routes and weights are kept for clients that are
+// present both before and after the replacement. Publishes already in
+// progress complete using the previous clients.
+func (pc *PubControl) ReplaceClients(clients []*PubControlClient) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.replaceClients(clients)
+}
+
+// An internal method that replaces the configured clients. The write lock
+// must be held by the caller.
+func (pc *PubControl) replaceClients(clients []*PubControlClient) {
+	kept := make(map[*PubControlClient]bool)
+	for _, pcc := range clients {
+		kept[pcc] = true
+	}
+	for pcc := range pc.routes {
+		if !kept[pcc] {
+			delete(pc.routes, pcc)
+		}
+	}
+	for pcc := range pc.weights {
+		if !kept[pcc] {
+			delete(pc.weights, pcc)
+		}
+	}
+	for pcc := range pc.entries {
+		if !kept[pcc] {
+			delete(pc.entries, pcc)
+		}
+	}
+	pc.clients = make([]*PubControlClient, len(clients))
+	copy(pc.clients, clients)
+	pc.updateRing()
+}
+
+// Get a snapshot of the configured PubControlClient instances.
+func (pc *PubControl) Clients() []*PubControlClient {
+	pc.clientsRWLock.RLock()
+	defer pc.clientsRWLock.RUnlock()
+	clients := make([]*PubControlClient, len(pc.clients))
+	copy(clients, pc.clients)
+	return clients
+}
+
+// Get the first configured PubControlClient instance with the specified
+// name or nil if there is none.
+func (pc *PubControl) Client(name string) *PubControlClient {
+	pc.clientsRWLock.RLock()
+	defer pc.clientsRWLock.RUnlock()
+	for _, pcc := range pc.clients {
+		if pcc.Name() == name {
+			return pcc
+		}
+	}
+	return nil
+}
+
+// Add the specified PubControlClient instance with the specified weight.
+// The weight only matters while sharding is enabled and determines the
+// share of channels assigned to the client relative to other clients.
+func (pc *PubControl) AddWeightedClient(pcc *PubControlClient, weight int) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.clients = append(pc.clients, pcc)
+	pc.weights[pcc] = weight
+	pc.updateRing()
+}
+
+// Enable sharding. While sharding is enabled each published item is sent
+// to exactly one client, chosen by a consistent hash of the channel name,
+// instead of to every client. Each client is placed on the hash ring at
+// the specified number of virtual nodes per unit of weight, or at a
+// default number of nodes if zero is specified. The publish policy is not
+// applied while sharding; a publish succeeds only if every client it was
+// sharded to succeeded.
+func (pc *PubControl) EnableSharding(virtualNodes int) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	pc.virtualNodes = virtualNodes
+	pc.updateRing()
+}
+
+// Disable sharding so that every item is sent to every client again.
+func (pc *PubControl) DisableSharding() {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.virtualNodes = 0
+	pc.updateRing()
+}
+
+// Set the sharding weight of a PubControlClient instance that was already
+// added. Clients have a weight of 1 by default.
+func (pc *PubControl) SetClientWeight(pcc *PubControlClient, weight int) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.weights[pcc] = weight
+	pc.updateRing()
+}
+
+// An internal method that rebuilds the hash ring after the clients or
+// their weights have changed. The write lock must be held by the caller.
+// Changes of the names of clients are picked up by refreshRing.
+func (pc *PubControl) updateRing() {
+	if pc.virtualNodes == 0 {
+		pc.ring = nil
+		return
+	}
+	pc.ring = newHashRing(pc.clients, pc.weights, pc.virtualNodes)
+}
+
+// An internal method that rebuilds the hash ring if the name or URI of a
+// client changed since it was built, such as when SetName was called on a
+// client that was already added.
+func (pc *PubControl) refreshRing() {
+	pc.clientsRWLock.RLock()
+	stale := pc.ring != nil && pc.ring.stale()
+	pc.clientsRWLock.RUnlock()
+	if !stale {
+		return
+	}
+	pc.clientsRWLock.Lock()
+	if pc.ring != nil && pc.ring.stale() {
+		pc.updateRing()
+	}
+	pc.clientsRWLock.Unlock()
+}
+
+// Add the specified PubControlClient instance and only publish channels
+// matching the specified route to it.
+func (pc *PubControl) AddClientWithRoute(pcc *PubControlClient,
+	route *Route) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.clients = append(pc.clients, pcc)
+	pc.setRoute(pcc, route)
+	pc.updateRing()
+}
+
+// Set the route of a PubControlClient instance that was already added.
+// Pass nil to publish all channels to the client again.
+func (pc *PubControl) SetClientRoute(pcc *PubControlClient, route *Route) {
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	pc.setRoute(pcc, route)
+}
+
+// An internal method that sets or clears the route of a client. The write
+// lock must be held by the caller.
+func (pc *PubControl) setRoute(pcc *PubControlClient, route *Route) {
+	if route == nil {
+		delete(pc.routes, pcc)
+	} else {
+		pc.routes[pcc] = route
+	}
 }
 
 // Apply the specified configuration to this PubControl instance. The
 // configuration object can either be a hash or an array of hashes where
 // each hash corresponds to a single PubControlClient instance. Each hash
 // will be parsed and a PubControlClient will be created either using just
-// a URI or a URI and JWT authentication information.
+// a URI or a URI and JWT authentication information. The optional 'name'
+// entry identifies the client in errors. The optional 'include'
+// and 'exclude' entries contain glob patterns that determine which
+// channels are routed to the client, and the optional 'weight' entry is
+// the sharding weight of the client. If merge mode is enabled then
+// existing clients are updated instead of being duplicated, see
+// ApplyConfigMerge.
 func (pc *PubControl) ApplyConfig(config []map[string]interface{}) {
 	pc.clientsRWLock.Lock()
 	defer pc.clientsRWLock.Unlock()
+	if pc.mergeConfig {
+		pc.applyConfigMerge(config)
+		return
+	}
 	for _, entry := range config {
-		if _, ok := entry["uri"]; !ok {
+		pcc := clientFromConfig(entry)
+		if pcc == nil {
+			if pc.logger != nil {
+				pc.logger.Warn("Skipped configuration entry without uri",
+					slog.Any("config", configLogValue(entry)))
+			}
 			continue
 		}
-		pcc := NewPubControlClient(entry["uri"].(string))
-		if _, ok := entry["iss"]; ok {
-			claim := make(map[string]interface{})
-			claim["iss"] = entry["iss"]
-			switch entry["key"].(type) {
-			case string:
-				pcc.SetAuthJwt(claim, []byte(entry["key"].(string)))
-			case []byte:
-				pcc.SetAuthJwt(claim, entry["key"].([]byte))
+		pc.clients = append(pc.clients, pcc)
+		pc.configureClient(pcc, entry)
+		if pc.logger != nil {
+			pc.logger.Info("Added client from configuration",
+				slog.Any("client", pcc),
+				slog.Any("config", configLogValue(entry)))
+		}
+	}
+	pc.updateRing()
+}
+
+// An internal method that sets the route and weight of a client from its
+// configuration entry and records the entry. The write lock must be held
+// by the caller.
+func (pc *PubControl) configureClient(pcc *PubControlClient,
+	entry map[string]interface{}) {
+	pc.entries[pcc] = entry
+	pc.setRoute(pcc, routeFromConfig(entry))
+	if weight, ok := intValue(entry["weight"]); ok {
+		pc.weights[pcc] = weight
+	} else {
+		delete(pc.weights, pcc)
+	}
+}
+
+// An internal function that creates a PubControlClient instance from a
+// configuration entry. Nil is returned if the entry has no URI.
+func clientFromConfig(entry map[string]interface{}) *PubControlClient {
+	if _, ok := entry["uri"]; !ok {
+		return nil
+	}
+	pcc := NewPubControlClient(entry["uri"].(string))
+	configureClientAuth(pcc, entry)
+	return pcc
+}
+
+// An internal function that sets the name, authentication, publish path,
+// extra headers and size limits of a client from a configuration entry,
+// replacing any previous settings. The settings are replaced under a
+// single hold of the client lock so that concurrent publishes use either
+// the previous or the new settings, never a mix of both.
+func configureClientAuth(pcc *PubControlClient, entry map[string]interface{}) {
+	name, _ := entry["name"].(string)
+	var claim map[string]interface{}
+	var jwtKey []byte
+	bearerKey := ""
+	if _, ok := entry["iss"]; ok {
+		switch key := entry["key"].(type) {
+		case string:
+			claim = map[string]interface{}{"iss": entry["iss"]}
+			jwtKey = []byte(key)
+		case []byte:
+			claim = map[string]interface{}{"iss": entry["iss"]}
+			jwtKey = key
+		}
+	} else if _, ok := entry["key"]; ok {
+		switch key := entry["key"].(type) {
+		case string:
+			bearerKey = key
+		case []byte:
+			bearerKey = string(key)
+		}
+	}
+	path, _ := entry["publish_path"].(string)
+	headers := headersFromConfig(entry["headers"])
+	maxItemSize, _ := intValue(entry["max_item_size"])
+	maxRequestSize, _ := intValue(entry["max_request_size"])
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	pcc.name = name
+	pcc.authBasicUser = ""
+	pcc.authBasicPass = ""
+	pcc.authJwtClaim = claim
+	pcc.authJwtKey = jwtKey
+	pcc.authBearerKey = bearerKey
+	pcc.publishPath = path
+	pcc.headers = headers
+	pcc.maxItemSize = maxItemSize
+	pcc.maxRequestSize = maxRequestSize
+}
+
+// An internal function that converts the headers of a configuration entry,
+// which map header names to a value or a list of values, into an
+// http.Header. Values that are not strings are ignored.
+func headersFromConfig(value interface{}) http.Header {
+	var headers http.Header
+	add := func(name string, value interface{}) {
+		if headers == nil {
+			headers = make(http.Header)
+		}
+		switch value := value.(type) {
+		case string:
+			headers.Add(name, value)
+		case []string:
+			for _, v := range value {
+				headers.Add(name, v)
 			}
-		} else if _, ok := entry["key"]; ok {
-			switch entry["key"].(type) {
-			case string:
-				pcc.SetAuthBearer(entry["key"].(string))
-			case []byte:
-				pcc.SetAuthBearer(string(entry["key"].([]byte)))
+		case []interface{}:
+			for _, v := range value {
+				if v, ok := v.(string); ok {
+					headers.Add(name, v)
+				}
 			}
 		}
-		pc.clients = append(pc.clients, pcc)
 	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, v := range value {
+			add(name, v)
+		}
+	case map[string]string:
+		for name, v := range value {
+			add(name, v)
+		}
+	case http.Header:
+		return value.Clone()
+	}
+	return headers
 }
 
 // The publish method for publishing the specified item to the specified
-// channel on the configured endpoints. Different endpoints are published to in parallel,
-// with this function waiting for them to finish. Any errors (including panics) are aggregated
-// into one error.
+// channel on the configured endpoints. How the endpoints are published to
+// is determined by the publish policy. With the default policy different
+// endpoints are published to in parallel, with this function waiting for
+// them to finish. Any errors (including panics) are aggregated into one
+// PubControlError error.
 func (pc *PubControl) Publish(channel string, item *Item) error {
+	return pc.PublishContext(context.Background(), channel, item)
+}
+
+// The publish method for publishing the specified item to the specified
+// channel on the configured endpoints. The context is passed to each
+// PubControlClient instance and can be used to cancel the publish.
+func (pc *PubControl) PublishContext(ctx context.Context, channel string,
+	item *Item) error {
+	return pc.PublishBatch(ctx, []ChannelItem{{Channel: channel, Item: item}})
+}
+
+// The publish method for publishing several items, each to its own
+// channel, on the configured endpoints. Each endpoint receives all of the
+// items in a single request. Channels that are empty, that are not valid
+// UTF-8 or that contain control characters fail the whole batch with a
+// ChannelError before anything is published. Use a ChannelValidator to
+// apply further rules.
+func (pc *PubControl) PublishBatch(ctx context.Context,
+	items []ChannelItem) error {
 	pc.clientsRWLock.RLock()
-	defer pc.clientsRWLock.RUnlock()
-	wg := sync.WaitGroup{}
-	errCh := make(chan string, len(pc.clients))
+	interceptors := pc.interceptors
+	metrics := pc.metrics
+	tracer := pc.tracer
+	logger := pc.logger
+	listener := pc.listener
+	pc.clientsRWLock.RUnlock()
+	ctx = withLogger(withTracer(ctx, tracer), logger)
+	ctx = withListener(ctx, listener)
+	ctx, endSpan := startPublishSpan(ctx, tracer,
+		"pubcontrol.Publish", trace.SpanKindInternal, items)
+	err := intercept(withMetrics(ctx, metrics), interceptors, items,
+		pc.publishBatch, dropNotifier(ctx, nil, nil))
+	endSpan(err)
+	return err
+}
 
+// An internal method that publishes the items that made it through the
+// interceptors to the configured endpoints.
+func (pc *PubControl) publishBatch(ctx context.Context,
+	items []ChannelItem) error {
+	for _, entry := range items {
+		if err := validateChannel(entry.Channel); err != nil {
+			if listener := listenerFromContext(ctx); listener != nil {
+				notifyListener(listener.OnDrop, nil, items, time.Now(), err)
+			}
+			return err
+		}
+	}
+	pc.refreshRing()
+	pc.clientsRWLock.RLock()
+	if pc.ring != nil {
+		batches := pc.shardBatches(items)
+		pc.clientsRWLock.RUnlock()
+		notifyUnrouted(ctx, items, batches)
+		return newPubControlError(items, batches,
+			publishParallel(ctx, batches, PublishPolicy{}))
+	}
+	batches := make([]clientBatch, 0, len(pc.clients))
 	for _, pcc := range pc.clients {
-		wg.Add(1)
-		client := pcc
+		route := pc.routes[pcc]
+		if route == nil {
+			batches = append(batches, clientBatch{client: pcc, items: items})
+			continue
+		}
+		routed := make([]ChannelItem, 0, len(items))
+		for _, entry := range items {
+			if route.matches(entry.Channel, entry.Item) {
+				routed = append(routed, entry)
+			}
+		}
+		if len(routed) > 0 {
+			batches = append(batches, clientBatch{client: pcc, items: routed})
+		}
+	}
+	policy := pc.policy
+	pc.clientsRWLock.RUnlock()
+	notifyUnrouted(ctx, items, batches)
+	var errs []ClientError
+	if policy.mode == policyFailover {
+		errs = publishFailover(ctx, batches)
+	} else {
+		errs = publishParallel(ctx, batches, policy)
+	}
+	return newPubControlError(items, batches, errs)
+}
+
+// An internal method that groups the specified items by the client that
+// owns their channel on the hash ring. Clients whose route does not match
+// an item are skipped in favour of the next client on the ring. The read
+// lock must be held by the caller.
+func (pc *PubControl) shardBatches(items []ChannelItem) []clientBatch {
+	batches := make([]clientBatch, 0)
+	index := make(map[*PubControlClient]int)
+	for _, entry := range items {
+		pcc := pc.ring.lookup(entry.Channel, func(pcc *PubControlClient) bool {
+			return pc.routes[pcc].matches(entry.Channel, entry.Item)
+		})
+		if pcc == nil {
+			continue
+		}
+		i, ok := index[pcc]
+		if !ok {
+			i = len(batches)
+			index[pcc] = i
+			batches = append(batches, clientBatch{client: pcc})
+		}
+		batches[i].items = append(batches[i].items, entry)
+	}
+	return batches
+}
+
+// An internal function that notifies the listener carried by the context
+// of the items that are not part of any of the specified batches.
+func notifyUnrouted(ctx context.Context, items []ChannelItem,
+	batches []clientBatch) {
+	dropped := dropNotifier(ctx, nil, &UnroutedError{
+		err: "No client route matched the channel"})
+	if dropped == nil {
+		return
+	}
+	routed := make(map[ChannelItem]bool)
+	for _, batch := range batches {
+		for _, entry := range batch.items {
+			routed[entry] = true
+		}
+	}
+	for _, entry := range items {
+		if !routed[entry] {
+			dropped(entry)
+		}
+	}
+}
+
+// An internal function that wraps the errors of a publish in a
+// PubControlError, or returns nil if there are none.
+func newPubControlError(items []ChannelItem, batches []clientBatch,
+	errs []ClientError) error {
+	if errs == nil {
+		return nil
+	}
+	return &PubControlError{channels: channelNames(items),
+		clientCount: len(batches), errs: errs}
+}
+
+// An internal error used as the cause when a publish policy cancels the
+// publishes still in flight after the result of the publish was known.
+var errRemainingCancelled = errors.New("Publish cancelled after the " +
+	"publish policy was satisfied")
+
+// An internal struct holding the items to be published to a single
+// client.
+type clientBatch struct {
+	client *PubControlClient
+	items  []ChannelItem
+}
+
+// An internal function that publishes to the clients of the specified
+// batches in parallel. The errors of the failed clients are returned if
+// fewer clients succeeded than required by the policy. Otherwise nil is
+// returned. Unless every client is required, the result is returned as
+// soon as it is known, possibly before all of the clients have finished.
+func publishParallel(ctx context.Context, batches []clientBatch,
+	policy PublishPolicy) []ClientError {
+	if policy.cancelRemaining {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(errRemainingCancelled)
+	}
+	results := make(chan *ClientError, len(batches))
+	for _, b := range batches {
+		batch := b
 		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					stack := make([]byte, 1024*8)
-					stack = stack[:runtime.Stack(stack, false)]
-					errCh <- fmt.Sprintf("%s: PANIC: %v\n%s", client.uri, err, stack)
-				}
-				wg.Done()
-			}()
+			results <- publishClient(ctx, batch.client, batch.items)
+		}()
+	}
+	required := policy.required(len(batches))
+	successes := 0
+	errs := make([]ClientError, 0)
+	for range batches {
+		result := <-results
+		if result != nil {
+			errs = append(errs, *result)
+			if len(errs) > len(batches)-required &&
+				policy.mode != policyAll {
+				return errs
+			}
+			continue
+		}
+		successes++
+		if successes >= required && policy.mode != policyAll {
+			return nil
+		}
+	}
+	if successes < required {
+		return errs
+	}
+	return nil
+}
 
-			err := client.Publish(channel, item)
-			if err != nil {
-				errCh <- fmt.Sprintf("%s: %s", client.uri, strings.TrimSpace(err.Error()))
+// An internal function that publishes to the clients of the specified
+// batches one at a time until one of them succeeds. The errors of the
+// failed clients are returned if none of them succeeded. Items that a
+// failed client reported as delivered in a PartialPublishError are not
+// published to the next clients.
+func publishFailover(ctx context.Context,
+	batches []clientBatch) []ClientError {
+	if len(batches) == 0 {
+		return nil
+	}
+	errs := make([]ClientError, 0)
+	delivered := make(map[ChannelItem]bool)
+	for i, batch := range batches {
+		if len(delivered) > 0 {
+			batch.items = undelivered(batch.items, delivered)
+			if len(batch.items) == 0 {
+				continue
 			}
-		}()
+		}
+		if i > 0 {
+			if metrics := batch.client.metricsFor(ctx); metrics != nil {
+				metrics.Retried(batch.client.label())
+			}
+			if listener := listenerFromContext(ctx); listener != nil {
+				notifyListener(listener.OnRetry, batch.client, batch.items,
+					time.Now(), errs[len(errs)-1].Err)
+			}
+			if logger := batch.client.loggerFor(ctx); logger != nil {
+				logger.LogAttrs(ctx, slog.LevelInfo,
+					"Retrying publish on next client",
+					slog.Any("client", batch.client),
+					slog.Any("previous", errs[len(errs)-1].Client),
+					slog.String("error", errs[len(errs)-1].Err.Error()))
+			}
+		}
+		result := publishClient(ctx, batch.client, batch.items)
+		if result == nil {
+			return nil
+		}
+		errs = append(errs, *result)
+		if partial, ok := result.Err.(*PartialPublishError); ok {
+			for _, entry := range partial.Delivered() {
+				delivered[entry] = true
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
 	}
-	wg.Wait()
-	close(errCh)
-	errs := make([]string, 0)
-	for err := range errCh {
-		errs = append(errs, err)
+	return errs
+}
+
+// An internal function that returns the specified items without those
+// that were delivered.
+func undelivered(items []ChannelItem,
+	delivered map[ChannelItem]bool) []ChannelItem {
+	remaining := make([]ChannelItem, 0, len(items))
+	for _, entry := range items {
+		if !delivered[entry] {
+			remaining = append(remaining, entry)
+		}
 	}
+	return remaining
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("%d/%d client(s) failed to publish to channel: %s Errors: [%s]",
-			len(errs), len(pc.clients), channel, strings.Join(errs, "],["))
+// An internal function that publishes to a single client and converts
+// an error or panic into a ClientError.
+func publishClient(ctx context.Context, client *PubControlClient,
+	items []ChannelItem) (result *ClientError) {
+	start := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			stack := make([]byte, 1024*8)
+			stack = stack[:runtime.Stack(stack, false)]
+			logPanic(client.loggerFor(ctx), client, err, stack)
+			result = &ClientError{Client: client,
+				Err: fmt.Errorf("PANIC: %v\n%s", err, stack)}
+			if listener := listenerFromContext(ctx); listener != nil {
+				notifyListener(listener.OnPublishFailure, client, items, start,
+					result.Err)
+			}
+		}
+	}()
+	if err := client.PublishBatch(ctx, items); err != nil {
+		return &ClientError{Client: client, Err: err}
 	}
 	return nil
 }
+
+// An internal function that returns the distinct channel names of the
+// specified items joined by commas.
+func channelNames(items []ChannelItem) string {
+	names := make([]string, 0, 1)
+	for _, entry := range items {
+		found := false
+		for _, name := range names {
+			if name == entry.Channel {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, entry.Channel)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// An internal function that converts a numeric configuration value, which
+// is a float64 when decoded from JSON, into an int.
+func intValue(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int:
+		return value, true
+	case int64:
+		return int(value), true
+	case float64:
+		return int(value), true
+	}
+	return 0, false
+}
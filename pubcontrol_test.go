@@ -8,8 +8,10 @@
 package pubcontrol
 
 import (
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"strings"
 	"testing"
 )
@@ -67,17 +69,42 @@ func TestApplyConfig(t *testing.T) {
 	assert.Equal(t, pc.clients[0].authJwtKey, []byte(nil))
 }
 
+func TestApplyConfigRequestSettings(t *testing.T) {
+	pc := NewPubControl(nil)
+	pc.ApplyConfig([]map[string]interface{}{
+		map[string]interface{}{
+			"uri":          "uri",
+			"publish_path": "/epcp/publish",
+			"headers": map[string]interface{}{
+				"X-Tenant": "tenant1",
+				"X-Multi":  []interface{}{"a", "b", 1}}},
+		map[string]interface{}{
+			"uri": "uri2"}})
+	assert.Equal(t, pc.clients[0].PublishPath(), "/epcp/publish")
+	assert.Equal(t, pc.clients[0].Headers(), http.Header{
+		"X-Tenant": {"tenant1"}, "X-Multi": {"a", "b"}})
+	assert.Equal(t, pc.clients[1].PublishPath(), DefaultPublishPath)
+	assert.Nil(t, pc.clients[1].Headers())
+	pc.SetMergeConfig(true)
+	pc.ApplyConfig([]map[string]interface{}{
+		map[string]interface{}{"uri": "uri"}})
+	assert.Equal(t, pc.clients[0].PublishPath(), DefaultPublishPath)
+	assert.Nil(t, pc.clients[0].Headers())
+}
+
 var publishResults1 []interface{} = nil
 
-func publish1(pcc *PubControlClient, channel string, item *Item) error {
-	publishResults1 = append(publishResults1, channel, item)
+func publish1(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error {
+	publishResults1 = append(publishResults1, items[0].Channel, items[0].Item)
 	return nil
 }
 
 var publishResults2 []interface{} = nil
 
-func publish2(pcc *PubControlClient, channel string, item *Item) error {
-	publishResults2 = append(publishResults2, channel, item)
+func publish2(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error {
+	publishResults2 = append(publishResults2, items[0].Channel, items[0].Item)
 	return nil
 }
 
@@ -102,7 +129,8 @@ func TestPcPublish(t *testing.T) {
 	assert.Equal(t, publishResults2[1], item)
 }
 
-func publishError(pcc *PubControlClient, channel string, item *Item) error {
+func publishError(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error {
 	return errors.New("Intentional error for tests")
 }
 
@@ -136,7 +164,8 @@ func TestPcPublishPartialError(t *testing.T) {
 	assert.Equal(t, publishResults2[1], item)
 }
 
-func publishPanic(pcc *PubControlClient, channel string, item *Item) error {
+func publishPanic(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error {
 	panic("Intentional panic for tests")
 }
 
@@ -170,3 +199,68 @@ func TestPcPublishPartialPanic(t *testing.T) {
 	assert.Equal(t, publishResults2[0], "chan")
 	assert.Equal(t, publishResults2[1], item)
 }
+
+func TestPcPublishBatchPartialError(t *testing.T) {
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	item := NewItem(formats, "id", "prev-id")
+	pc := NewPubControl(nil)
+	pcc := NewPubControlClient("errorUri")
+	pcc.publish = publishError
+	pc.AddClient(pcc)
+	err := pc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "chan", Item: item}, {Channel: "chan2", Item: item},
+		{Channel: "chan", Item: item}})
+	assert.Error(t, err)
+	assert.Equal(t, "1/1 client(s) failed to publish to channel: chan, chan2 Errors: [errorUri: Intentional error for tests]", err.Error())
+}
+
+func TestPcRemoveClient(t *testing.T) {
+	pc := NewPubControl(nil)
+	pcc1 := NewPubControlClient("uri1")
+	pcc2 := NewPubControlClient("uri2")
+	pc.AddClientWithRoute(pcc1, &Route{})
+	pc.AddWeightedClient(pcc2, 2)
+	pc.EnableSharding(1)
+	assert.True(t, pc.RemoveClient(pcc1))
+	assert.False(t, pc.RemoveClient(pcc1))
+	assert.Equal(t, pc.Clients(), []*PubControlClient{pcc2})
+	assert.Equal(t, len(pc.routes), 0)
+	assert.Equal(t, len(pc.ring.points), 2)
+	assert.True(t, pc.RemoveClient(pcc2))
+	assert.Equal(t, len(pc.weights), 0)
+	assert.Equal(t, len(pc.Clients()), 0)
+}
+
+func TestPcReplaceClients(t *testing.T) {
+	pc := NewPubControl(nil)
+	pcc1 := NewPubControlClient("uri1")
+	pcc2 := NewPubControlClient("uri2")
+	pcc3 := NewPubControlClient("uri3")
+	pc.AddClientWithRoute(pcc1, &Route{})
+	pc.AddClientWithRoute(pcc2, &Route{})
+	clients := []*PubControlClient{pcc2, pcc3}
+	pc.ReplaceClients(clients)
+	clients[0] = pcc1
+	assert.Equal(t, pc.Clients(), []*PubControlClient{pcc2, pcc3})
+	assert.Nil(t, pc.routes[pcc1])
+	assert.NotNil(t, pc.routes[pcc2])
+	snapshot := pc.Clients()
+	snapshot[0] = pcc1
+	assert.Equal(t, pc.clients[0], pcc2)
+}
+
+func TestPcClientNames(t *testing.T) {
+	pc := NewPubControl([]map[string]interface{}{
+		map[string]interface{}{"uri": "uri", "name": "primary"},
+		map[string]interface{}{"uri": "uri"}})
+	assert.Equal(t, pc.Client("primary"), pc.clients[0])
+	assert.Nil(t, pc.Client("secondary"))
+	pc.clients[0].publish = publishError
+	pc.clients[1].publish = publishError
+	err := pc.Publish("chan", newPolicyTestItem())
+	assert.True(t, strings.Contains(err.Error(),
+		"[primary: Intentional error for tests]"))
+	assert.True(t, strings.Contains(err.Error(),
+		"[uri: Intentional error for tests]"))
+}
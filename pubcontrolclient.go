@@ -9,12 +9,17 @@ package pubcontrol
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,15 +27,16 @@ import (
 )
 
 // An internal type used to define the Publish method.
-type publisher func(pcc *PubControlClient, channel string, item *Item) error
+type publisher func(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error
 
 // An internal type used to define the pubCall method.
-type pubCaller func(pcc *PubControlClient, uri, authHeader string,
-	items []map[string]interface{}) error
+type pubCaller func(pcc *PubControlClient, ctx context.Context, uri,
+	authHeader string, items []map[string]interface{}) error
 
 // An internal type used to define the makeHttpRequest method.
-type makeHttpRequester func(pcc *PubControlClient, uri, authHeader string,
-	jsonContent []byte) (int, []byte, error)
+type makeHttpRequester func(pcc *PubControlClient, ctx context.Context, uri,
+	authHeader string, jsonContent []byte) (int, []byte, error)
 
 // The PubControlClient struct allows consumers to publish to an endpoint of
 // their choice. The consumer wraps a Format struct instance in an Item struct
@@ -39,6 +45,7 @@ type makeHttpRequester func(pcc *PubControlClient, uri, authHeader string,
 // complete to notify the consumer of the result.
 type PubControlClient struct {
 	uri             string
+	name            string
 	isWorkerRunning bool
 	lock            *sync.Mutex
 	authBasicUser   string
@@ -50,8 +57,23 @@ type PubControlClient struct {
 	pubCall         pubCaller
 	makeHttpRequest makeHttpRequester
 	httpClient      *http.Client
+	breaker         *CircuitBreaker
+	limiter         *RateLimiter
+	interceptors    []Interceptor
+	metrics         MetricsCollector
+	tracer          trace.Tracer
+	logger          *slog.Logger
+	transport       Transport
+	publishPath     string
+	headers         http.Header
+	maxItemSize     int
+	maxRequestSize  int
 }
 
+// The path that is appended to the URI of an endpoint to publish to it,
+// unless a different path is set with the SetPublishPath method.
+const DefaultPublishPath = "/publish/"
+
 // Initialize this struct with a URL representing the publishing endpoint.
 func NewPubControlClient(uri string) *PubControlClient {
 	// This is basically the same as the default in Go 1.6, but with these changes:
@@ -79,6 +101,41 @@ func NewPubControlClient(uri string) *PubControlClient {
 	return newPcc
 }
 
+// Get the URI of the publishing endpoint.
+func (pcc *PubControlClient) Uri() string {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.uri
+}
+
+// Set an optional name used to identify this client in errors instead of
+// its URI, for example when several clients share the same URI. The name
+// also places the client on the hash ring of PubControl instances that
+// shard channels, which pick up the new name on their next publish.
+func (pcc *PubControlClient) SetName(name string) {
+	pcc.lock.Lock()
+	pcc.name = name
+	pcc.lock.Unlock()
+}
+
+// Get the name of this client or an empty string if none was set.
+func (pcc *PubControlClient) Name() string {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.name
+}
+
+// An internal method that returns the name of this client if one was set
+// and its URI otherwise.
+func (pcc *PubControlClient) label() string {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	if pcc.name != "" {
+		return pcc.name
+	}
+	return pcc.uri
+}
+
 // Call this method and pass a username and password to use basic
 // authentication with the configured endpoint.
 func (pcc *PubControlClient) SetAuthBasic(username, password string) {
@@ -104,6 +161,151 @@ func (pcc *PubControlClient) SetAuthBearer(key string) {
 	pcc.lock.Unlock()
 }
 
+// Call this method and pass a CircuitBreaker instance to fail publishes
+// immediately while the configured endpoint is unhealthy. Pass nil to
+// disable the circuit breaker.
+func (pcc *PubControlClient) SetCircuitBreaker(breaker *CircuitBreaker) {
+	pcc.lock.Lock()
+	pcc.breaker = breaker
+	pcc.lock.Unlock()
+}
+
+// Get the CircuitBreaker instance used by this client or nil if none was
+// set.
+func (pcc *PubControlClient) CircuitBreaker() *CircuitBreaker {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.breaker
+}
+
+// Call this method and pass a RateLimiter instance to limit the rate of
+// items and requests sent to the configured endpoint as well as the number
+// of concurrent requests. Pass nil to disable rate limiting.
+func (pcc *PubControlClient) SetRateLimiter(limiter *RateLimiter) {
+	pcc.lock.Lock()
+	pcc.limiter = limiter
+	pcc.lock.Unlock()
+}
+
+// Get the RateLimiter instance used by this client or nil if none was set.
+func (pcc *PubControlClient) RateLimiter() *RateLimiter {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.limiter
+}
+
+// Add an interceptor that is called for every item published through this
+// client before it is exported. Interceptors are called in the order they
+// were added, the first one being outermost.
+func (pcc *PubControlClient) AddInterceptor(interceptor Interceptor) {
+	pcc.lock.Lock()
+	pcc.interceptors = append(pcc.interceptors, interceptor)
+	pcc.lock.Unlock()
+}
+
+// Set the collector that publish metrics of this client are recorded to.
+// If no collector is set then the collector of the PubControl instance
+// publishing through this client, if any, is used.
+func (pcc *PubControlClient) SetMetrics(metrics MetricsCollector) {
+	pcc.lock.Lock()
+	pcc.metrics = metrics
+	pcc.lock.Unlock()
+}
+
+// An internal method that returns the metrics collector to use for a
+// publish with the specified context, or nil if there is none.
+func (pcc *PubControlClient) metricsFor(ctx context.Context) MetricsCollector {
+	pcc.lock.Lock()
+	metrics := pcc.metrics
+	pcc.lock.Unlock()
+	if metrics == nil {
+		metrics = metricsFromContext(ctx)
+	}
+	return metrics
+}
+
+// Set the OpenTelemetry tracer provider used to create a span for every
+// publish of this client. If no provider is set then the provider of the
+// PubControl instance publishing through this client, if any, is used.
+// Set nil to disable tracing.
+func (pcc *PubControlClient) SetTracerProvider(provider trace.TracerProvider) {
+	pcc.lock.Lock()
+	pcc.tracer = newTracer(provider)
+	pcc.lock.Unlock()
+}
+
+// An internal method that returns the tracer to use for a publish with
+// the specified context, or nil if there is none.
+func (pcc *PubControlClient) tracerFor(ctx context.Context) trace.Tracer {
+	pcc.lock.Lock()
+	tracer := pcc.tracer
+	pcc.lock.Unlock()
+	if tracer == nil {
+		tracer = tracerFromContext(ctx)
+	}
+	return tracer
+}
+
+// Set the path that is appended to the path of the endpoint URI to
+// publish to it, for gateways that mount EPCP somewhere other than the
+// default '/publish/'. The query string of the URI is kept. Pass an empty
+// string to restore the default.
+func (pcc *PubControlClient) SetPublishPath(path string) {
+	pcc.lock.Lock()
+	pcc.publishPath = path
+	pcc.lock.Unlock()
+}
+
+// Get the path that is appended to the endpoint URI to publish to it.
+func (pcc *PubControlClient) PublishPath() string {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	if pcc.publishPath == "" {
+		return DefaultPublishPath
+	}
+	return pcc.publishPath
+}
+
+// Set extra headers that are sent with every publish request, for example
+// a tenant identifier or the API key of a gateway. The headers are copied.
+// The Content-Type header cannot be overridden and the Authorization
+// header is only sent if no authentication is configured. Pass nil to
+// remove the extra headers.
+func (pcc *PubControlClient) SetHeaders(headers http.Header) {
+	pcc.lock.Lock()
+	pcc.headers = headers.Clone()
+	pcc.lock.Unlock()
+}
+
+// Get a copy of the extra headers sent with every publish request.
+func (pcc *PubControlClient) Headers() http.Header {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.headers.Clone()
+}
+
+// Call this method and pass a Transport instance to deliver items through
+// it instead of publishing them to the configured endpoint over HTTP.
+// Authentication settings only apply to the default HTTP transport. Pass
+// nil to restore the default.
+func (pcc *PubControlClient) SetTransport(transport Transport) {
+	pcc.lock.Lock()
+	pcc.transport = transport
+	pcc.lock.Unlock()
+}
+
+// Get the Transport instance used by this client, which is the default
+// HTTP transport unless another one was set. The result can be wrapped to
+// decorate the current transport.
+func (pcc *PubControlClient) Transport() Transport {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	if pcc.transport == nil {
+		return httpTransport{pcc: pcc}
+	}
+	return pcc.transport
+}
+
 // An internal method used to generate an authorization header. The
 // authorization header is generated based on whether basic or JWT
 // authorization information was provided via the publicly accessible
@@ -139,70 +341,306 @@ func (pcc *PubControlClient) generateAuthHeader() (string, error) {
 // The publish method for publishing the specified item to the specified
 // channel on the configured endpoint.
 func (pcc *PubControlClient) Publish(channel string, item *Item) error {
-	return pcc.publish(pcc, channel, item)
+	return pcc.PublishContext(context.Background(), channel, item)
 }
 
-// An internal publish method to facilitate testing.
-func publish(pcc *PubControlClient, channel string, item *Item) error {
-	export, err := item.Export()
-	if err != nil {
-		return err
-	}
-	export["channel"] = channel
-	uri := ""
-	auth := ""
+// The publish method for publishing the specified item to the specified
+// channel on the configured endpoint. The context can be used to cancel
+// the publish while it waits for the rate limiter or the endpoint.
+func (pcc *PubControlClient) PublishContext(ctx context.Context,
+	channel string, item *Item) error {
+	return pcc.PublishBatch(ctx, []ChannelItem{{Channel: channel, Item: item}})
+}
+
+// The publish method for publishing several items, each to its own
+// channel, on the configured endpoint in a single request. If a circuit
+// breaker is set and open then a CircuitOpenError is returned without
+// contacting the endpoint. If a rate limiter is set then the publish waits
+// for or fails on the configured limits depending on its mode.
+func (pcc *PubControlClient) PublishBatch(ctx context.Context,
+	items []ChannelItem) error {
 	pcc.lock.Lock()
-	uri = pcc.uri
-	auth, err = pcc.generateAuthHeader()
+	interceptors := pcc.interceptors
 	pcc.lock.Unlock()
-	if err != nil {
-		return err
+	return intercept(ctx, interceptors, items, pcc.publishBatch,
+		dropNotifier(ctx, pcc, nil))
+}
+
+// An internal method that publishes the items that made it through the
+// interceptors, subject to the circuit breaker and rate limiter.
+func (pcc *PubControlClient) publishBatch(ctx context.Context,
+	items []ChannelItem) (err error) {
+	pcc.lock.Lock()
+	breaker := pcc.breaker
+	limiter := pcc.limiter
+	maxItemSize := pcc.maxItemSize
+	maxRequestSize := pcc.maxRequestSize
+	pcc.lock.Unlock()
+	label := pcc.label()
+	ctx, endSpan := startPublishSpan(ctx, pcc.tracerFor(ctx),
+		"pubcontrol.PublishClient", trace.SpanKindClient, items,
+		ClientAttribute.String(label))
+	defer func() {
+		endSpan(err)
+	}()
+	metrics := pcc.metricsFor(ctx)
+	if metrics != nil {
+		start := time.Now()
+		defer func() {
+			if partial, ok := err.(*PartialPublishError); ok {
+				delivered := len(partial.Delivered())
+				metrics.PublishCompleted(label, delivered, time.Since(start),
+					nil)
+				metrics.PublishCompleted(label, len(items)-delivered,
+					time.Since(start), err)
+				return
+			}
+			metrics.PublishCompleted(label, len(items), time.Since(start), err)
+		}()
 	}
-	err = pcc.pubCall(pcc, uri, auth, [](map[string]interface{}){export})
-	if err != nil {
+	if logger := pcc.loggerFor(ctx); logger != nil {
+		logger.LogAttrs(ctx, slog.LevelDebug, "Publishing items",
+			slog.Any("client", pcc), slog.String("channel", channelNames(items)),
+			slog.Int("items", len(items)))
+		start := time.Now()
+		defer func() {
+			logPublish(ctx, logger, pcc, items, time.Since(start), err)
+		}()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// Report the panic as the error of the publish to the deferred
+			// calls above before passing it on to the caller.
+			err = fmt.Errorf("PANIC: %v", r)
+			panic(r)
+		}
+	}()
+	listener := listenerFromContext(ctx)
+	start := time.Now()
+	settled := false
+	counts := []int{len(items)}
+	var encoded [][]byte
+	if maxItemSize > 0 || maxRequestSize > 0 {
+		encoded, err = encodeItems(items, maxItemSize, maxRequestSize)
+		if err != nil {
+			if listener != nil {
+				notifyListener(listener.OnDrop, pcc, items, start, err)
+			}
+			return err
+		}
+		counts = splitCounts(encoded, maxRequestSize)
+	}
+	if breaker != nil {
+		if err := breaker.allow(); err != nil {
+			if listener != nil {
+				notifyListener(listener.OnDrop, pcc, items, start, err)
+			}
+			return err
+		}
+		defer func() {
+			if !settled {
+				// A panic is in progress. Count it as a failure so that a
+				// half-open probe is not left in flight forever.
+				breaker.record(errors.New("Publish panicked"))
+			}
+		}()
+	}
+	var onQueue func(depth int)
+	if metrics != nil {
+		onQueue = func(depth int) {
+			metrics.QueueDepthChanged(label, depth)
+		}
+	}
+	// Batches exceeding the maximum request size are published with one
+	// request per part, each of which is subject to the rate limiter.
+	delivered := 0
+	limited := false
+	for _, count := range counts {
+		part := items[delivered : delivered+count]
+		partCtx := ctx
+		if encoded != nil {
+			partCtx = withEncodedItems(ctx, part,
+				encoded[delivered:delivered+count])
+		}
+		var sent int
+		sent, limited, err = pcc.publishPart(partCtx, part, limiter, onQueue)
+		delivered += sent
+		if err != nil {
+			break
+		}
+	}
+	if limited && delivered == 0 {
+		if breaker != nil {
+			breaker.cancel()
+		}
+		settled = true
+		if listener != nil {
+			notifyListener(listener.OnDrop, pcc, items, start, err)
+		}
 		return err
 	}
-	return nil
+	if err != nil && delivered > 0 {
+		if partial, ok := err.(*PartialPublishError); ok {
+			err = partial.cause
+		}
+		partial := newPartialPublishError(delivered, len(items), err)
+		partial.delivered = items[:delivered]
+		err = partial
+	}
+	if listener != nil {
+		notifyListener(listener.OnPublishSuccess, pcc, items[:delivered],
+			start, nil)
+		if limited {
+			notifyListener(listener.OnDrop, pcc, items[delivered:], start, err)
+		} else if err != nil {
+			notifyListener(listener.OnPublishFailure, pcc, items[delivered:],
+				start, err)
+		}
+	}
+	settled = true
+	if breaker != nil {
+		if limited {
+			// The endpoint accepted the requests made before the rate
+			// limiter refused the next one.
+			breaker.record(nil)
+		} else if isItemError(err) || (err != nil &&
+			context.Cause(ctx) == errRemainingCancelled) {
+			// Invalid items say nothing about the health of the endpoint,
+			// and neither do publishes cancelled by the publish policy
+			// because other clients already decided the result.
+			breaker.cancel()
+		} else {
+			breaker.record(err)
+		}
+	}
+	return err
+}
+
+// An internal method that publishes a part of a batch that fits into a
+// single request once the rate limiter allows it. The number of items
+// that were delivered is returned, along with whether the rate limiter
+// refused the part and the error of the rate limiter or the publish.
+func (pcc *PubControlClient) publishPart(ctx context.Context,
+	items []ChannelItem, limiter *RateLimiter,
+	onQueue func(depth int)) (int, bool, error) {
+	if limiter != nil {
+		release, err := limiter.acquire(ctx, len(items), onQueue)
+		if err != nil {
+			return 0, true, err
+		}
+		defer release()
+	}
+	err := pcc.publish(pcc, ctx, items)
+	if partial, ok := err.(*PartialPublishError); ok {
+		return partial.count, false, err
+	} else if err != nil {
+		return 0, false, err
+	}
+	return len(items), false, nil
+}
+
+// An internal publish method to facilitate testing.
+func publish(pcc *PubControlClient, ctx context.Context,
+	items []ChannelItem) error {
+	return pcc.Transport().Send(ctx, items)
 }
 
 // An internal method for preparing the HTTP POST request for publishing
 // data to the endpoint. This method accepts the URI endpoint, authorization
-// header, and a list of items to publish.
-func pubCall(pcc *PubControlClient, uri, authHeader string,
-	items []map[string]interface{}) error {
-	uri = strings.Join([]string{uri, "/publish/"}, "")
-	content := make(map[string]interface{})
-	content["items"] = items
-	var jsonContent []byte
-	jsonContent, err := json.Marshal(content)
+// header, and a list of items to publish. If a maximum request size is set
+// then the items are split across as many requests as needed, which are
+// made in order until one fails. A PartialPublishError is returned if a
+// request fails after earlier ones succeeded.
+func pubCall(pcc *PubControlClient, ctx context.Context, uri,
+	authHeader string, items []map[string]interface{}) error {
+	uri, err := publishUrl(uri, pcc.PublishPath())
 	if err != nil {
 		return err
 	}
-	statusCode, body, err := pcc.makeHttpRequest(pcc, uri, authHeader,
-		jsonContent)
+	encoded, err := encodeExports(items, pcc.MaxItemSize(),
+		pcc.MaxRequestSize())
 	if err != nil {
 		return err
 	}
-	if statusCode < 200 || statusCode >= 300 {
-		return &PublishError{err: strings.Join([]string{"Failure status code: ",
-			strconv.Itoa(statusCode), " with message: ",
-			string(body)}, "")}
+	return pcc.sendRequests(ctx, uri, authHeader,
+		splitRequests(encoded, pcc.MaxRequestSize()), len(items))
+}
+
+// An internal method that makes the specified publish requests, containing
+// the specified total number of items, in order until one fails. A
+// PartialPublishError is returned if a request fails after earlier ones
+// succeeded.
+func (pcc *PubControlClient) sendRequests(ctx context.Context, uri,
+	authHeader string, requests []publishRequest, total int) error {
+	delivered := 0
+	for _, request := range requests {
+		err := pcc.sendRequest(ctx, uri, authHeader, request.content)
+		if err != nil && delivered > 0 {
+			return newPartialPublishError(delivered, total, err)
+		} else if err != nil {
+			return err
+		}
+		delivered += request.count
 	}
 	return nil
 }
 
+// An internal method that sends a single publish request with the
+// specified JSON content and records its result.
+func (pcc *PubControlClient) sendRequest(ctx context.Context, uri,
+	authHeader string, jsonContent []byte) error {
+	start := time.Now()
+	statusCode, body, err := pcc.makeHttpRequest(pcc, ctx, uri, authHeader,
+		jsonContent)
+	if err == nil && (statusCode < 200 || statusCode >= 300) {
+		err = &PublishError{err: strings.Join([]string{"Failure status code: ",
+			strconv.Itoa(statusCode), " with message: ",
+			string(body)}, ""), statusCode: statusCode}
+	}
+	setSpanStatusCode(ctx, statusCode)
+	if metrics := pcc.metricsFor(ctx); metrics != nil {
+		metrics.RequestCompleted(pcc.label(), statusCode, len(jsonContent),
+			time.Since(start), err)
+	}
+	return err
+}
+
+// An internal function that returns the URL to publish to for the
+// specified endpoint URI by appending the publish path to its path. The
+// query string of the URI is kept and its fragment is removed.
+func publishUrl(uri, path string) (string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	parsed.Fragment = ""
+	parsed.RawFragment = ""
+	return parsed.JoinPath(path).String(), nil
+}
+
 // An internal method used to make the HTTP request for publishing based
 // on the specified URI, auth header, and JSON content. An HTTP status
 // code, response body, and an error will be returned.
-func makeHttpRequest(pcc *PubControlClient, uri, authHeader string,
-	jsonContent []byte) (int, []byte, error) {
+func makeHttpRequest(pcc *PubControlClient, ctx context.Context, uri,
+	authHeader string, jsonContent []byte) (int, []byte, error) {
 	var req *http.Request
-	req, err := http.NewRequest("POST", uri, bytes.NewReader(jsonContent))
+	req, err := http.NewRequestWithContext(ctx, "POST", uri,
+		bytes.NewReader(jsonContent))
 	if err != nil {
 		return 0, nil, err
 	}
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", authHeader)
+	pcc.lock.Lock()
+	for name, values := range pcc.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	pcc.lock.Unlock()
+	req.Header.Set("Content-Type", "application/json")
+	if authHeader != "" || req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	injectTraceContext(ctx, req.Header)
 	resp, err := pcc.httpClient.Do(req)
 	if err != nil {
 		return 0, nil, err
@@ -218,7 +656,14 @@ func makeHttpRequest(pcc *PubControlClient, uri, authHeader string,
 
 // An error struct used to represent an error encountered during publishing.
 type PublishError struct {
-	err string
+	err        string
+	statusCode int
+}
+
+// Get the HTTP status code returned by the endpoint or zero if the error
+// was not caused by a failure status code.
+func (e PublishError) StatusCode() int {
+	return e.statusCode
 }
 
 // This function returns the message associated with the Publish error struct.
@@ -9,6 +9,7 @@ package pubcontrol
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -26,6 +27,16 @@ func TestPccInitialize(t *testing.T) {
 	assert.NotNil(t, pcc.lock)
 }
 
+func TestPccName(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	assert.Equal(t, pcc.Uri(), "uri")
+	assert.Equal(t, pcc.Name(), "")
+	assert.Equal(t, pcc.label(), "uri")
+	pcc.SetName("name")
+	assert.Equal(t, pcc.Name(), "name")
+	assert.Equal(t, pcc.label(), "name")
+}
+
 func TestPccSetAuthBasic(t *testing.T) {
 	pcc := NewPubControlClient("uri")
 	pcc.SetAuthBasic("user", "pass")
@@ -70,14 +81,14 @@ func TestPccGenerateAuthHeaderBearer(t *testing.T) {
 
 var pubCallResults []interface{} = nil
 
-func pubCallTestMethod(pcc *PubControlClient, uri, authHeader string,
-	items []map[string]interface{}) error {
+func pubCallTestMethod(pcc *PubControlClient, ctx context.Context, uri,
+	authHeader string, items []map[string]interface{}) error {
 	pubCallResults = append(pubCallResults, uri, authHeader, items)
 	return nil
 }
 
-func pubCallTestMethodFailure(pcc *PubControlClient, uri, authHeader string,
-	items []map[string]interface{}) error {
+func pubCallTestMethodFailure(pcc *PubControlClient, ctx context.Context,
+	uri, authHeader string, items []map[string]interface{}) error {
 	return &PublishError{err: "error"}
 }
 
@@ -140,14 +151,15 @@ func TestPccPublishErrorPubCall(t *testing.T) {
 
 var makeHttpRequestResults []interface{} = nil
 
-func makeHttpRequestTestMethod(pcc *PubControlClient, uri, authHeader string,
-	jsonContent []byte) (int, []byte, error) {
+func makeHttpRequestTestMethod(pcc *PubControlClient, ctx context.Context,
+	uri, authHeader string, jsonContent []byte) (int, []byte, error) {
 	makeHttpRequestResults = append(makeHttpRequestResults, uri, authHeader,
 		jsonContent)
 	return 200, nil, nil
 }
-func makeHttpRequestTestMethodFailure(pcc *PubControlClient, uri,
-	authHeader string, jsonContent []byte) (int, []byte, error) {
+func makeHttpRequestTestMethodFailure(pcc *PubControlClient,
+	ctx context.Context, uri, authHeader string,
+	jsonContent []byte) (int, []byte, error) {
 	return 300, []byte("body"), &PublishError{err: "message"}
 }
 
@@ -157,7 +169,7 @@ func TestPccPubCall(t *testing.T) {
 	items = append(items, map[string]interface{}{"item": "value"})
 	pcc := NewPubControlClient("uri")
 	pcc.makeHttpRequest = makeHttpRequestTestMethod
-	err := pcc.pubCall(pcc, "http://uri.com", "auth header", items)
+	err := pcc.pubCall(pcc, context.Background(), "http://uri.com", "auth header", items)
 	assert.Nil(t, err)
 	assert.Equal(t, makeHttpRequestResults[0], "http://uri.com/publish/")
 	assert.Equal(t, makeHttpRequestResults[1], "auth header")
@@ -167,10 +179,46 @@ func TestPccPubCall(t *testing.T) {
 	assert.Equal(t, makeHttpRequestResults[2], jsonContent)
 }
 
+func TestPublishUrl(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"http://uri.com":               "http://uri.com/publish/",
+		"http://uri.com/":              "http://uri.com/publish/",
+		"http://uri.com/realm/x":       "http://uri.com/realm/x/publish/",
+		"http://uri.com/realm/x/":      "http://uri.com/realm/x/publish/",
+		"http://uri.com/realm?a=1&b=2": "http://uri.com/realm/publish/?a=1&b=2",
+		"http://uri.com/a%2Fb#frag":    "http://uri.com/a%2Fb/publish/",
+		"uri":                          "uri/publish/"} {
+		result, err := publishUrl(uri, DefaultPublishPath)
+		assert.Nil(t, err)
+		assert.Equal(t, result, expected)
+	}
+	result, err := publishUrl("http://uri.com/gw/?key=1", "epcp")
+	assert.Nil(t, err)
+	assert.Equal(t, result, "http://uri.com/gw/epcp?key=1")
+	_, err = publishUrl("http://uri.com/%zz", DefaultPublishPath)
+	assert.NotNil(t, err)
+}
+
+func TestPccPubCallPublishPath(t *testing.T) {
+	makeHttpRequestResults = nil
+	pcc := NewPubControlClient("uri")
+	pcc.makeHttpRequest = makeHttpRequestTestMethod
+	assert.Equal(t, pcc.PublishPath(), DefaultPublishPath)
+	pcc.SetPublishPath("/epcp/publish")
+	assert.Equal(t, pcc.PublishPath(), "/epcp/publish")
+	err := pcc.pubCall(pcc, context.Background(), "http://uri.com/realm?x=1",
+		"", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, makeHttpRequestResults[0],
+		"http://uri.com/realm/epcp/publish?x=1")
+	pcc.SetPublishPath("")
+	assert.Equal(t, pcc.PublishPath(), DefaultPublishPath)
+}
+
 func TestPccPubCallError(t *testing.T) {
 	pcc := NewPubControlClient("uri")
 	pcc.makeHttpRequest = makeHttpRequestTestMethodFailure
-	err := pcc.pubCall(pcc, "http://uri.com", "", nil)
+	err := pcc.pubCall(pcc, context.Background(), "http://uri.com", "", nil)
 	assert.NotNil(t, err)
 }
 
@@ -197,18 +245,78 @@ func TestPccMakeHttpRequest(t *testing.T) {
 		},
 	}
 	pcc.httpClient = &http.Client{Transport: transport}
-	code, body, err := pcc.makeHttpRequest(pcc, "http://uri.com", "auth header",
+	code, body, err := pcc.makeHttpRequest(pcc, context.Background(), "http://uri.com", "auth header",
 		[]byte("content"))
 	assert.Equal(t, code, 200)
 	assert.Equal(t, string(body), "body\n")
 	assert.Nil(t, err)
 }
 
+func TestPccMakeHttpRequestHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(
+		writer http.ResponseWriter, request *http.Request) {
+		received = request.Header
+	}))
+	defer server.Close()
+	pcc := NewPubControlClient(server.URL)
+	headers := http.Header{"X-Tenant": {"a", "b"},
+		"Content-Type": {"text/plain"}, "Authorization": {"Key gw"}}
+	pcc.SetHeaders(headers)
+	headers.Set("X-Tenant", "changed")
+	_, _, err := pcc.makeHttpRequest(pcc, context.Background(), server.URL,
+		"Bearer token", []byte("content"))
+	assert.Nil(t, err)
+	assert.Equal(t, received.Values("X-Tenant"), []string{"a", "b"})
+	assert.Equal(t, received.Values("Content-Type"),
+		[]string{"application/json"})
+	assert.Equal(t, received.Get("Authorization"), "Bearer token")
+	_, _, err = pcc.makeHttpRequest(pcc, context.Background(), server.URL,
+		"", []byte("content"))
+	assert.Nil(t, err)
+	assert.Equal(t, received.Get("Authorization"), "Key gw")
+	pcc.SetHeaders(nil)
+	assert.Nil(t, pcc.Headers())
+	_, _, err = pcc.makeHttpRequest(pcc, context.Background(), server.URL,
+		"", []byte("content"))
+	assert.Nil(t, err)
+	assert.Equal(t, received.Get("X-Tenant"), "")
+}
+
 func TestPccMakeHttpRequestError(t *testing.T) {
 	pcc := NewPubControlClient("uri")
-	code, body, err := pcc.makeHttpRequest(pcc, "xxx://uri.com", "auth header",
+	code, body, err := pcc.makeHttpRequest(pcc, context.Background(), "xxx://uri.com", "auth header",
 		[]byte("content"))
 	assert.Equal(t, code, 0)
 	assert.Equal(t, body, []byte(nil))
 	assert.NotNil(t, err)
 }
+
+func TestPccPublishBatch(t *testing.T) {
+	pubCallResults = nil
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	item := NewItem(formats, "id", "")
+	item2 := NewItem(formats, "id2", "")
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	err := pcc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "chan", Item: item}, {Channel: "chan2", Item: item2}})
+	assert.Nil(t, err)
+	export, _ := item.Export()
+	export["channel"] = "chan"
+	export2, _ := item2.Export()
+	export2["channel"] = "chan2"
+	assert.Equal(t, pubCallResults[2], [](map[string]interface{}){export,
+		export2})
+}
+
+func TestPccMakeHttpRequestContext(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code, _, err := pcc.makeHttpRequest(pcc, ctx, "http://uri.com",
+		"auth header", []byte("content"))
+	assert.Equal(t, code, 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,129 @@
+//    prometheus.go
+//    ~~~~~~~~~
+//    This module implements the Prometheus metrics collector.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+// Package pubcontrolprom records the publish metrics of the pubcontrol
+// package with the Prometheus client library.
+package pubcontrolprom
+
+import (
+	"github.com/fanout/go-pubcontrol"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// The Collector struct implements both the pubcontrol MetricsCollector and
+// the Prometheus Collector interfaces. Register it with a Prometheus
+// registry and pass it to the SetMetrics method of a PubControl or
+// PubControlClient instance.
+type Collector struct {
+	publishes       *prometheus.CounterVec
+	publishFailures *prometheus.CounterVec
+	batchSize       *prometheus.HistogramVec
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	bytesSent       *prometheus.CounterVec
+	queueDepth      *prometheus.GaugeVec
+	retries         *prometheus.CounterVec
+}
+
+// Initialize this struct with the namespace that prefixes the metric
+// names, such as "pubcontrol". The namespace may be empty.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		publishes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "publishes_total",
+			Help: "Number of batches published per client."},
+			[]string{"client"}),
+		publishFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "publish_failures_total",
+			Help: "Number of failed publishes per client and status class."},
+			[]string{"client", "class"}),
+		batchSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "batch_size_items",
+			Help:    "Number of items per published batch.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10)},
+			[]string{"client"}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "requests_total",
+			Help: "Number of publish requests per client and status class."},
+			[]string{"client", "class"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "request_duration_seconds",
+			Help:    "Latency of publish requests.",
+			Buckets: prometheus.DefBuckets},
+			[]string{"client"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "bytes_sent_total",
+			Help: "Number of request body bytes sent per client."},
+			[]string{"client"}),
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "queue_depth",
+			Help: "Number of publishes waiting for the rate limiter."},
+			[]string{"client"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "retries_total",
+			Help: "Number of retried publishes per client."},
+			[]string{"client"}),
+	}
+}
+
+// An internal method that returns all of the metric vectors.
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.publishes, c.publishFailures,
+		c.batchSize, c.requests, c.requestDuration, c.bytesSent,
+		c.queueDepth, c.retries}
+}
+
+// Send the descriptors of all metrics to the specified channel.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Send the current values of all metrics to the specified channel.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// Record a completed publish. Failures are counted by the status class
+// of the response or "error" if the failure was not caused by a status
+// code.
+func (c *Collector) PublishCompleted(client string, items int,
+	duration time.Duration, err error) {
+	c.publishes.WithLabelValues(client).Inc()
+	c.batchSize.WithLabelValues(client).Observe(float64(items))
+	if err != nil {
+		statusCode := 0
+		if publishErr, ok := err.(*pubcontrol.PublishError); ok {
+			statusCode = publishErr.StatusCode()
+		}
+		c.publishFailures.WithLabelValues(client,
+			pubcontrol.StatusClass(statusCode)).Inc()
+	}
+}
+
+// Record a completed publish request.
+func (c *Collector) RequestCompleted(client string, statusCode int,
+	bytesSent int, duration time.Duration, err error) {
+	c.requests.WithLabelValues(client,
+		pubcontrol.StatusClass(statusCode)).Inc()
+	c.requestDuration.WithLabelValues(client).Observe(duration.Seconds())
+	c.bytesSent.WithLabelValues(client).Add(float64(bytesSent))
+}
+
+// Record the current rate limiter queue depth of a client.
+func (c *Collector) QueueDepthChanged(client string, depth int) {
+	c.queueDepth.WithLabelValues(client).Set(float64(depth))
+}
+
+// Record a retried publish.
+func (c *Collector) Retried(client string) {
+	c.retries.WithLabelValues(client).Inc()
+}
@@ -0,0 +1,47 @@
+//    prometheus_test.go
+//    ~~~~~~~~~
+//    This module implements the Prometheus metrics collector tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrolprom
+
+import (
+	"errors"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var _ pubcontrol.MetricsCollector = (*Collector)(nil)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector("pubcontrol")
+	registry := prometheus.NewRegistry()
+	assert.Nil(t, registry.Register(c))
+	c.PublishCompleted("main", 3, time.Millisecond, nil)
+	c.PublishCompleted("main", 1, time.Millisecond, errors.New("failed"))
+	c.RequestCompleted("main", 200, 120, 5*time.Millisecond, nil)
+	c.QueueDepthChanged("main", 4)
+	c.Retried("backup")
+	assert.Equal(t, testutil.ToFloat64(c.publishes.WithLabelValues("main")),
+		2.0)
+	assert.Equal(t, testutil.ToFloat64(
+		c.publishFailures.WithLabelValues("main", "error")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(
+		c.requests.WithLabelValues("main", "2xx")), 1.0)
+	assert.Equal(t, testutil.ToFloat64(c.bytesSent.WithLabelValues("main")),
+		120.0)
+	assert.Equal(t, testutil.ToFloat64(c.queueDepth.WithLabelValues("main")),
+		4.0)
+	assert.Equal(t, testutil.ToFloat64(c.retries.WithLabelValues("backup")),
+		1.0)
+	count, err := testutil.GatherAndCount(registry,
+		"pubcontrol_request_duration_seconds", "pubcontrol_batch_size_items")
+	assert.Nil(t, err)
+	assert.Equal(t, count, 2)
+}
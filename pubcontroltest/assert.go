@@ -0,0 +1,81 @@
+//    assert.go
+//    ~~~~~~~~~
+//    This module implements the fake EPCP server assertion helpers.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontroltest
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// The TestingT interface is the subset of testing.TB used by the assertion
+// helpers.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Assert that the server accepted exactly the specified number of items.
+func (s *Server) AssertItemCount(t TestingT, count int) bool {
+	t.Helper()
+	if received := len(s.Items()); received != count {
+		t.Errorf("Expected %d published item(s), got %d", count, received)
+		return false
+	}
+	return true
+}
+
+// Assert that the server accepted at least one item on the specified
+// channel.
+func (s *Server) AssertPublished(t TestingT, channel string) bool {
+	t.Helper()
+	if len(s.ItemsOn(channel)) == 0 {
+		t.Errorf("Expected an item published to channel %q", channel)
+		return false
+	}
+	return true
+}
+
+// Assert that the server accepted no item on the specified channel.
+func (s *Server) AssertNotPublished(t TestingT, channel string) bool {
+	t.Helper()
+	if count := len(s.ItemsOn(channel)); count > 0 {
+		t.Errorf("Expected no item published to channel %q, got %d",
+			channel, count)
+		return false
+	}
+	return true
+}
+
+// Assert that the server accepted an item on the specified channel whose
+// format with the specified name equals the expected value. The expected
+// value is compared after a round trip through JSON, so structs and maps
+// may be passed as well as the exported values of a Formatter.
+func (s *Server) AssertFormat(t TestingT, channel, format string,
+	expected interface{}) bool {
+	t.Helper()
+	encoded, err := json.Marshal(expected)
+	if err != nil {
+		t.Errorf("Cannot encode expected value: %v", err)
+		return false
+	}
+	var decoded interface{}
+	json.Unmarshal(encoded, &decoded)
+	items := s.ItemsOn(channel)
+	for _, item := range items {
+		if reflect.DeepEqual(item.Formats[format], decoded) {
+			return true
+		}
+	}
+	actual := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		actual = append(actual, item.Formats[format])
+	}
+	t.Errorf("Expected an item on channel %q with %s format %v, got %v",
+		channel, format, decoded, actual)
+	return false
+}
@@ -0,0 +1,133 @@
+//    publisher.go
+//    ~~~~~~~~~
+//    This module implements the RecordingPublisher struct.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontroltest
+
+import (
+	"context"
+	"github.com/fanout/go-pubcontrol"
+	"sync"
+)
+
+// The RecordingPublisher struct is an in-memory pubcontrol.Publisher that
+// records the published items instead of sending them anywhere. It is
+// safe for concurrent use.
+type RecordingPublisher struct {
+	lock  sync.Mutex
+	items []pubcontrol.ChannelItem
+	errs  []error
+	err   error
+}
+
+var _ pubcontrol.Publisher = (*RecordingPublisher)(nil)
+
+// Initialize an empty recording publisher.
+func NewRecordingPublisher() *RecordingPublisher {
+	return &RecordingPublisher{}
+}
+
+// Record the specified item.
+func (p *RecordingPublisher) Publish(channel string,
+	item *pubcontrol.Item) error {
+	return p.PublishContext(context.Background(), channel, item)
+}
+
+// Record the specified item. The context error is returned without
+// recording the item if the context is already done.
+func (p *RecordingPublisher) PublishContext(ctx context.Context,
+	channel string, item *pubcontrol.Item) error {
+	return p.PublishBatch(ctx, []pubcontrol.ChannelItem{
+		{Channel: channel, Item: item}})
+}
+
+// Record the specified items. The context error is returned without
+// recording the items if the context is already done. If an error was
+// queued with FailNext or set with FailAll then it is returned and the
+// items are not recorded.
+func (p *RecordingPublisher) PublishBatch(ctx context.Context,
+	items []pubcontrol.ChannelItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return err
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.items = append(p.items, items...)
+	return nil
+}
+
+// Queue errors that are returned by the next publishes, one per publish.
+func (p *RecordingPublisher) FailNext(errs ...error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.errs = append(p.errs, errs...)
+}
+
+// Make every publish return the specified error once the queued errors
+// are exhausted. Pass nil to make publishes succeed again.
+func (p *RecordingPublisher) FailAll(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.err = err
+}
+
+// Get the items recorded so far in the order they were published.
+func (p *RecordingPublisher) Items() []pubcontrol.ChannelItem {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	items := make([]pubcontrol.ChannelItem, len(p.items))
+	copy(items, p.items)
+	return items
+}
+
+// Get the recorded items published to the specified channel.
+func (p *RecordingPublisher) ItemsOn(channel string) []*pubcontrol.Item {
+	items := make([]*pubcontrol.Item, 0)
+	for _, entry := range p.Items() {
+		if entry.Channel == channel {
+			items = append(items, entry.Item)
+		}
+	}
+	return items
+}
+
+// Discard the recorded items and any queued or permanent error.
+func (p *RecordingPublisher) Reset() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.items = nil
+	p.errs = nil
+	p.err = nil
+}
+
+// Assert that exactly the specified number of items were recorded.
+func (p *RecordingPublisher) AssertItemCount(t TestingT, count int) bool {
+	t.Helper()
+	if recorded := len(p.Items()); recorded != count {
+		t.Errorf("Expected %d published item(s), got %d", count, recorded)
+		return false
+	}
+	return true
+}
+
+// Assert that at least one item was recorded on the specified channel.
+func (p *RecordingPublisher) AssertPublished(t TestingT,
+	channel string) bool {
+	t.Helper()
+	if len(p.ItemsOn(channel)) == 0 {
+		t.Errorf("Expected an item published to channel %q", channel)
+		return false
+	}
+	return true
+}
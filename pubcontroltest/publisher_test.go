@@ -0,0 +1,57 @@
+//    publisher_test.go
+//    ~~~~~~~~~
+//    This module implements the RecordingPublisher tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontroltest
+
+import (
+	"context"
+	"errors"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func publishGreeting(publisher pubcontrol.Publisher) error {
+	return publisher.Publish("greetings", newTestItem("hello"))
+}
+
+func TestRecordingPublisher(t *testing.T) {
+	publisher := NewRecordingPublisher()
+	assert.Nil(t, publishGreeting(publisher))
+	item := newTestItem("batch")
+	assert.Nil(t, publisher.PublishBatch(context.Background(),
+		[]pubcontrol.ChannelItem{{Channel: "a", Item: item},
+			{Channel: "b", Item: item}}))
+	assert.Equal(t, len(publisher.Items()), 3)
+	assert.Equal(t, publisher.Items()[1],
+		pubcontrol.ChannelItem{Channel: "a", Item: item})
+	assert.Equal(t, publisher.ItemsOn("b"), []*pubcontrol.Item{item})
+	publisher.AssertItemCount(t, 3)
+	publisher.AssertPublished(t, "greetings")
+	recorder := &recordingT{}
+	assert.False(t, publisher.AssertPublished(recorder, "other"))
+	assert.False(t, publisher.AssertItemCount(recorder, 1))
+	assert.Equal(t, len(recorder.errors), 2)
+	publisher.Reset()
+	publisher.AssertItemCount(t, 0)
+}
+
+func TestRecordingPublisherErrors(t *testing.T) {
+	publisher := NewRecordingPublisher()
+	failed := errors.New("failed")
+	publisher.FailNext(failed)
+	assert.Equal(t, publishGreeting(publisher), failed)
+	assert.Nil(t, publishGreeting(publisher))
+	publisher.FailAll(failed)
+	assert.Equal(t, publishGreeting(publisher), failed)
+	publisher.FailAll(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, publisher.PublishContext(ctx, "chan",
+		newTestItem("body")), context.Canceled)
+	publisher.AssertItemCount(t, 1)
+}
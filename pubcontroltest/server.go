@@ -0,0 +1,320 @@
+//    server.go
+//    ~~~~~~~~~
+//    This module implements the fake EPCP server.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+// Package pubcontroltest provides utilities for testing code that
+// publishes with the pubcontrol package, most notably a fake EPCP
+// endpoint running in the same process.
+package pubcontroltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fanout/go-pubcontrol"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The Response struct describes a scripted response of the fake server.
+// A zero status code defaults to 200.
+type Response struct {
+	StatusCode int
+	Body       string
+	Header     http.Header
+	Delay      time.Duration
+}
+
+// Get a response with the specified status code and body.
+func Status(statusCode int, body string) Response {
+	return Response{StatusCode: statusCode, Body: body}
+}
+
+// Get a 429 response with the specified Retry-After value in seconds.
+func TooManyRequests(retryAfter int) Response {
+	header := make(http.Header)
+	header.Set("Retry-After", strconv.Itoa(retryAfter))
+	return Response{StatusCode: http.StatusTooManyRequests,
+		Body: "Too Many Requests", Header: header}
+}
+
+// Get a successful response that is sent after the specified delay.
+func Delay(delay time.Duration) Response {
+	return Response{Delay: delay}
+}
+
+// The ReceivedItem struct represents an item received by the fake server.
+// The formats are keyed by format name and hold the decoded JSON values.
+type ReceivedItem struct {
+	Channel string
+	Id      string
+	PrevId  string
+	Formats map[string]interface{}
+}
+
+// The ReceivedRequest struct represents a request received by the fake
+// server along with the status code it was answered with.
+type ReceivedRequest struct {
+	Header     http.Header
+	Body       []byte
+	Items      []ReceivedItem
+	StatusCode int
+}
+
+// The Server struct is a fake EPCP endpoint. It accepts publish requests
+// on /publish/, records the received items and answers with the scripted
+// responses, or with 200 once the script is exhausted. Initialize it with
+// NewServer and pass its URL to a PubControlClient.
+type Server struct {
+	server    *httptest.Server
+	lock      sync.Mutex
+	auth      pubcontrol.AuthVerifier
+	responses []Response
+	fallback  Response
+	discard   bool
+	requests  []ReceivedRequest
+	items     []ReceivedItem
+	changed   chan struct{}
+}
+
+// Initialize and start a fake server. Call Close once it is no longer
+// needed.
+func NewServer() *Server {
+	s := &Server{changed: make(chan struct{})}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Get the base URI of the server.
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Shut down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Require requests to use basic authentication with the specified
+// credentials. Other requests are answered with 401.
+func (s *Server) RequireBasic(user, pass string) {
+	s.setAuth(pubcontrol.VerifyBasic(user, pass))
+}
+
+// Require requests to use bearer authentication with the specified token.
+// Other requests are answered with 401.
+func (s *Server) RequireBearer(token string) {
+	s.setAuth(pubcontrol.VerifyBearer(token))
+}
+
+// Require requests to carry an unexpired JWT signed with HS256 using the
+// specified key. If the issuer is not empty then the iss claim must match
+// it. Other requests are answered with 401.
+func (s *Server) RequireJwt(iss string, key []byte) {
+	s.setAuth(pubcontrol.VerifyJwt(iss, key))
+}
+
+// Accept requests regardless of their authentication.
+func (s *Server) AllowAnyAuth() {
+	s.setAuth(nil)
+}
+
+// An internal method that sets the authentication check.
+func (s *Server) setAuth(auth pubcontrol.AuthVerifier) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auth = auth
+}
+
+// Queue the specified responses. Each request that passes authentication
+// consumes the next queued response.
+func (s *Server) Respond(responses ...Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses = append(s.responses, responses...)
+}
+
+// Set the response used once the queued responses are exhausted. It
+// defaults to a 200 response without delay.
+func (s *Server) SetDefaultResponse(response Response) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fallback = response
+}
+
+// Stop recording requests and items, for example to keep memory use
+// constant under load. WaitForItems cannot be used while recording is
+// disabled.
+func (s *Server) SetRecording(enabled bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.discard = !enabled
+}
+
+// Get the requests received so far, including rejected ones.
+func (s *Server) Requests() []ReceivedRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := make([]ReceivedRequest, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Get the items of all requests that were answered with a 2xx status
+// code, in the order they were received.
+func (s *Server) Items() []ReceivedItem {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	items := make([]ReceivedItem, len(s.items))
+	copy(items, s.items)
+	return items
+}
+
+// Get the accepted items published to the specified channel.
+func (s *Server) ItemsOn(channel string) []ReceivedItem {
+	items := make([]ReceivedItem, 0)
+	for _, item := range s.Items() {
+		if item.Channel == channel {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Wait until at least the specified number of items were accepted or the
+// timeout expires. False is returned on timeout.
+func (s *Server) WaitForItems(count int, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.lock.Lock()
+		received := len(s.items)
+		changed := s.changed
+		s.lock.Unlock()
+		if received >= count {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// Discard the recorded requests and items as well as any queued
+// responses.
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responses = nil
+	s.requests = nil
+	s.items = nil
+}
+
+// An internal method that handles a request to the server.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/publish/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := ReceivedRequest{Header: r.Header.Clone(), Body: body}
+	response := s.nextResponse(r.Header.Get("Authorization"))
+	if response.StatusCode == http.StatusOK || response.StatusCode == 0 {
+		request.Items, err = parseItems(body)
+		if err != nil {
+			response = Status(http.StatusBadRequest, err.Error())
+		}
+	}
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+		}
+	}
+	if response.StatusCode == 0 {
+		response.StatusCode = http.StatusOK
+	}
+	if response.Body == "" && response.StatusCode == http.StatusOK {
+		response.Body = "Published"
+	}
+	request.StatusCode = response.StatusCode
+	s.record(request)
+	for name, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+	io.WriteString(w, response.Body)
+}
+
+// An internal method that returns the response for a request with the
+// specified authorization header, consuming a scripted response unless
+// the request fails authentication.
+func (s *Server) nextResponse(authHeader string) Response {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.auth != nil && !s.auth(authHeader) {
+		return Status(http.StatusUnauthorized, "Unauthorized")
+	}
+	if len(s.responses) == 0 {
+		return s.fallback
+	}
+	response := s.responses[0]
+	s.responses = s.responses[1:]
+	return response
+}
+
+// An internal method that records a request and its accepted items.
+func (s *Server) record(request ReceivedRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.discard {
+		return
+	}
+	s.requests = append(s.requests, request)
+	if request.StatusCode >= 200 && request.StatusCode < 300 {
+		s.items = append(s.items, request.Items...)
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// An internal function that decodes and validates the items of a publish
+// request body.
+func parseItems(body []byte) ([]ReceivedItem, error) {
+	parsed, err := pubcontrol.ParsePublishRequest(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	items := make([]ReceivedItem, 0, len(parsed))
+	for _, item := range parsed {
+		received := ReceivedItem{Channel: item.Channel, Id: item.Id,
+			PrevId: item.PrevId, Formats: make(map[string]interface{})}
+		for _, format := range item.Formats {
+			var value interface{}
+			if err := json.Unmarshal(format.Raw, &value); err != nil {
+				return nil, err
+			}
+			received.Formats[format.Name] = value
+		}
+		items = append(items, received)
+	}
+	return items, nil
+}
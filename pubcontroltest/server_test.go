@@ -0,0 +1,174 @@
+//    server_test.go
+//    ~~~~~~~~~
+//    This module implements the fake EPCP server tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontroltest
+
+import (
+	"context"
+	"fmt"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testFormat struct {
+	Body string
+}
+
+func (f *testFormat) Name() string {
+	return "http-response"
+}
+
+func (f *testFormat) Export() interface{} {
+	return map[string]interface{}{"body": f.Body}
+}
+
+func newTestItem(body string) *pubcontrol.Item {
+	return pubcontrol.NewItem([]pubcontrol.Formatter{&testFormat{Body: body}},
+		"id", "prev")
+}
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestServerRecordsItems(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+	assert.Nil(t, pcc.PublishBatch(context.Background(),
+		[]pubcontrol.ChannelItem{
+			{Channel: "a", Item: newTestItem("one")},
+			{Channel: "b", Item: newTestItem("two")}}))
+	items := server.Items()
+	assert.Equal(t, len(items), 2)
+	assert.Equal(t, items[0], ReceivedItem{Channel: "a", Id: "id",
+		PrevId: "prev", Formats: map[string]interface{}{
+			"http-response": map[string]interface{}{"body": "one"}}})
+	assert.Equal(t, len(server.Requests()), 1)
+	assert.Equal(t, server.Requests()[0].Header.Get("Content-Type"),
+		"application/json")
+	server.AssertItemCount(t, 2)
+	server.AssertPublished(t, "b")
+	server.AssertNotPublished(t, "c")
+	server.AssertFormat(t, "a", "http-response",
+		map[string]string{"body": "one"})
+	server.Reset()
+	server.AssertItemCount(t, 0)
+}
+
+type jsonObjectFormat struct{}
+
+func (f *jsonObjectFormat) Name() string {
+	return "json-object"
+}
+
+func (f *jsonObjectFormat) Export() interface{} {
+	return map[string]interface{}{"event": "update"}
+}
+
+func TestServerOtherFormats(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+	assert.Nil(t, pcc.Publish("a", pubcontrol.NewItem(
+		[]pubcontrol.Formatter{&jsonObjectFormat{}}, "", "")))
+	server.AssertFormat(t, "a", "json-object",
+		map[string]string{"event": "update"})
+}
+
+func TestServerAuth(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+
+	server.RequireBasic("user", "pass")
+	assert.NotNil(t, pcc.Publish("chan", newTestItem("body")))
+	pcc.SetAuthBasic("user", "pass")
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+
+	server.RequireBearer("token")
+	pcc = pubcontrol.NewPubControlClient(server.URL())
+	pcc.SetAuthBearer("wrong")
+	assert.NotNil(t, pcc.Publish("chan", newTestItem("body")))
+	pcc.SetAuthBearer("token")
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+
+	server.RequireJwt("realm", []byte("key"))
+	pcc = pubcontrol.NewPubControlClient(server.URL())
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "realm"}, []byte("wrong"))
+	err := pcc.Publish("chan", newTestItem("body"))
+	assert.Equal(t, err.(*pubcontrol.PublishError).StatusCode(), 401)
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "other"}, []byte("key"))
+	assert.NotNil(t, pcc.Publish("chan", newTestItem("body")))
+	pcc.SetAuthJwt(map[string]interface{}{"iss": "realm"}, []byte("key"))
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+
+	server.AllowAnyAuth()
+	pcc = pubcontrol.NewPubControlClient(server.URL())
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+	assert.Equal(t, len(server.Requests()), 8)
+	server.AssertItemCount(t, 4)
+}
+
+func TestServerScript(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Respond(TooManyRequests(5), Status(500, "broken"),
+		Delay(50*time.Millisecond))
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+	err := pcc.Publish("chan", newTestItem("body"))
+	assert.Equal(t, err.(*pubcontrol.PublishError).StatusCode(), 429)
+	err = pcc.Publish("chan", newTestItem("body"))
+	assert.True(t, strings.Contains(err.Error(), "broken"))
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	assert.NotNil(t, pcc.PublishContext(ctx, "chan", newTestItem("body")))
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+	assert.True(t, server.WaitForItems(2, time.Second))
+	requests := server.Requests()
+	assert.Equal(t, requests[0].StatusCode, 429)
+	assert.Equal(t, requests[1].StatusCode, 500)
+	server.AssertItemCount(t, 2)
+}
+
+func TestServerDefaultResponse(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetDefaultResponse(Status(503, "unavailable"))
+	server.Respond(Response{})
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+	assert.NotNil(t, pcc.Publish("chan", newTestItem("body")))
+	server.SetRecording(false)
+	assert.NotNil(t, pcc.Publish("chan", newTestItem("body")))
+	assert.Equal(t, len(server.Requests()), 2)
+}
+
+func TestServerAssertFailures(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	pcc := pubcontrol.NewPubControlClient(server.URL())
+	assert.Nil(t, pcc.Publish("chan", newTestItem("body")))
+	recorder := &recordingT{}
+	assert.False(t, server.AssertItemCount(recorder, 2))
+	assert.False(t, server.AssertPublished(recorder, "other"))
+	assert.False(t, server.AssertNotPublished(recorder, "chan"))
+	assert.False(t, server.AssertFormat(recorder, "chan", "http-response",
+		map[string]string{"body": "other"}))
+	assert.Equal(t, len(recorder.errors), 4)
+	assert.False(t, server.WaitForItems(2, 10*time.Millisecond))
+}
@@ -0,0 +1,49 @@
+//    publisher.go
+//    ~~~~~~~~~
+//    This module implements the Publisher interface.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+)
+
+// The Publisher interface is implemented by PubControl and
+// PubControlClient. Depend on it instead of the concrete types so that
+// NoopPublisher or the RecordingPublisher of the pubcontroltest package
+// can be substituted in tests.
+type Publisher interface {
+	Publish(channel string, item *Item) error
+	PublishContext(ctx context.Context, channel string, item *Item) error
+	PublishBatch(ctx context.Context, items []ChannelItem) error
+}
+
+var (
+	_ Publisher = (*PubControl)(nil)
+	_ Publisher = (*PubControlClient)(nil)
+	_ Publisher = NoopPublisher{}
+)
+
+// The NoopPublisher struct is a Publisher that discards every item and
+// never fails.
+type NoopPublisher struct{}
+
+// Discard the specified item.
+func (NoopPublisher) Publish(channel string, item *Item) error {
+	return nil
+}
+
+// Discard the specified item.
+func (NoopPublisher) PublishContext(ctx context.Context, channel string,
+	item *Item) error {
+	return nil
+}
+
+// Discard the specified items.
+func (NoopPublisher) PublishBatch(ctx context.Context,
+	items []ChannelItem) error {
+	return nil
+}
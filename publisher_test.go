@@ -0,0 +1,36 @@
+//    publisher_test.go
+//    ~~~~~~~~~
+//    This module implements the Publisher tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNoopPublisher(t *testing.T) {
+	var publisher Publisher = NoopPublisher{}
+	item := newPolicyTestItem()
+	assert.Nil(t, publisher.Publish("chan", item))
+	assert.Nil(t, publisher.PublishContext(context.Background(), "chan", item))
+	assert.Nil(t, publisher.PublishBatch(context.Background(),
+		[]ChannelItem{{Channel: "chan", Item: item}}))
+}
+
+func TestPublisherImplementations(t *testing.T) {
+	publishResults1 = nil
+	pcc := NewPubControlClient("uri")
+	pcc.publish = publish1
+	pc := NewPubControl(nil)
+	pc.AddClient(pcc)
+	item := newPolicyTestItem()
+	for _, publisher := range []Publisher{pc, pcc} {
+		assert.Nil(t, publisher.Publish("chan", item))
+	}
+	assert.Equal(t, len(publishResults1), 4)
+}
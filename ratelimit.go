@@ -0,0 +1,297 @@
+//    ratelimit.go
+//    ~~~~~~~~~
+//    This module implements the RateLimiter functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// The LimitMode type determines what happens to a publish when a
+// RateLimiter has no capacity left.
+type LimitMode int
+
+const (
+	// Wait until capacity is available or the context is done.
+	LimitBlock LimitMode = iota
+
+	// Wait like LimitBlock, but only while fewer than MaxQueue publishes
+	// are already waiting. Otherwise fail immediately.
+	LimitQueue
+
+	// Fail immediately with a RateLimitError.
+	LimitFailFast
+)
+
+// The RateLimitConfig struct contains the settings used by a RateLimiter
+// instance. A zero rate or zero MaxInFlight disables that limit.
+type RateLimitConfig struct {
+
+	// The sustained number of items that may be published per second and
+	// the number of items that may be published in a burst. The burst
+	// defaults to one second worth of items.
+	ItemsPerSecond float64
+	ItemBurst      int
+
+	// The sustained number of HTTP requests that may be made per second
+	// and the number of requests that may be made in a burst. The burst
+	// defaults to one second worth of requests.
+	RequestsPerSecond float64
+	RequestBurst      int
+
+	// The maximum number of requests that may be in flight at once.
+	MaxInFlight int
+
+	// What to do when the limit is reached. Defaults to LimitBlock.
+	Mode LimitMode
+
+	// The maximum number of publishes waiting for capacity when Mode is
+	// LimitQueue. Defaults to 100.
+	MaxQueue int
+}
+
+// The RateLimiter struct limits the rate of items and requests sent to a
+// single endpoint as well as the number of concurrent requests. The item
+// and request rates are enforced using token buckets.
+type RateLimiter struct {
+	config   RateLimitConfig
+	items    *tokenBucket
+	requests *tokenBucket
+	inFlight chan struct{}
+	lock     sync.Mutex
+	waiting  int
+	now      func() time.Time
+}
+
+// Initialize this struct with the specified configuration.
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if config.MaxQueue <= 0 {
+		config.MaxQueue = 100
+	}
+	rl := new(RateLimiter)
+	rl.config = config
+	rl.now = time.Now
+	if config.ItemsPerSecond > 0 {
+		rl.items = newTokenBucket(config.ItemsPerSecond, config.ItemBurst)
+	}
+	if config.RequestsPerSecond > 0 {
+		rl.requests = newTokenBucket(config.RequestsPerSecond,
+			config.RequestBurst)
+	}
+	if config.MaxInFlight > 0 {
+		rl.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	return rl
+}
+
+// Get the number of publishes currently waiting for capacity.
+func (rl *RateLimiter) QueueDepth() int {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	return rl.waiting
+}
+
+// Get the number of requests currently in flight.
+func (rl *RateLimiter) InFlight() int {
+	if rl.inFlight == nil {
+		return 0
+	}
+	return len(rl.inFlight)
+}
+
+// An internal method used to acquire capacity for a single request
+// containing the specified number of items. On success a function is
+// returned that must be called once the request has completed. The
+// optional onQueue function is called with the new queue depth whenever
+// this call starts or stops waiting. Calls that get capacity immediately
+// never wait and are not counted against the maximum queue size.
+func (rl *RateLimiter) acquire(ctx context.Context, itemCount int,
+	onQueue func(depth int)) (func(), error) {
+	release, err := rl.tryAcquire(itemCount)
+	if err == nil || rl.config.Mode == LimitFailFast {
+		return release, err
+	}
+	rl.lock.Lock()
+	if rl.config.Mode == LimitQueue && rl.waiting >= rl.config.MaxQueue {
+		rl.lock.Unlock()
+		return nil, &RateLimitError{err: "Rate limit queue is full"}
+	}
+	rl.waiting++
+	depth := rl.waiting
+	rl.lock.Unlock()
+	if onQueue != nil {
+		onQueue(depth)
+	}
+	defer func() {
+		rl.lock.Lock()
+		rl.waiting--
+		depth := rl.waiting
+		rl.lock.Unlock()
+		if onQueue != nil {
+			onQueue(depth)
+		}
+	}()
+	if err := rl.wait(ctx, rl.items, itemCount); err != nil {
+		return nil, err
+	}
+	if err := rl.wait(ctx, rl.requests, 1); err != nil {
+		rl.refund(itemCount, false)
+		return nil, err
+	}
+	if rl.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case rl.inFlight <- struct{}{}:
+		return rl.release, nil
+	case <-ctx.Done():
+		rl.refund(itemCount, true)
+		return nil, ctx.Err()
+	}
+}
+
+// An internal method that returns the item tokens and optionally the
+// request token taken by an acquire call that failed afterwards.
+func (rl *RateLimiter) refund(itemCount int, request bool) {
+	if rl.items != nil {
+		rl.items.refund(itemCount)
+	}
+	if request && rl.requests != nil {
+		rl.requests.refund(1)
+	}
+}
+
+// An internal method used to acquire capacity without waiting.
+func (rl *RateLimiter) tryAcquire(itemCount int) (func(), error) {
+	if rl.inFlight != nil {
+		select {
+		case rl.inFlight <- struct{}{}:
+		default:
+			return nil, &RateLimitError{err: "Too many requests in flight"}
+		}
+	}
+	now := rl.now()
+	if rl.items != nil && !rl.items.take(now, itemCount) {
+		rl.release()
+		return nil, &RateLimitError{err: "Item rate limit exceeded"}
+	}
+	if rl.requests != nil && !rl.requests.take(now, 1) {
+		if rl.items != nil {
+			rl.items.refund(itemCount)
+		}
+		rl.release()
+		return nil, &RateLimitError{err: "Request rate limit exceeded"}
+	}
+	return rl.release, nil
+}
+
+// An internal method that releases an in-flight slot.
+func (rl *RateLimiter) release() {
+	if rl.inFlight != nil {
+		<-rl.inFlight
+	}
+}
+
+// An internal method that waits until the specified number of tokens can
+// be taken from the bucket or the context is done.
+func (rl *RateLimiter) wait(ctx context.Context, bucket *tokenBucket,
+	n int) error {
+	if bucket == nil {
+		return nil
+	}
+	for {
+		delay, ok := bucket.reserve(rl.now(), n)
+		if ok {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// An internal token bucket. Requests for more tokens than the burst size
+// are allowed once the bucket is full and leave the bucket in debt.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst),
+		tokens: float64(burst)}
+}
+
+// An internal method that adds the tokens accumulated since the last call.
+// The lock must be held by the caller.
+func (tb *tokenBucket) refill(now time.Time) {
+	if !tb.last.IsZero() && now.After(tb.last) {
+		tb.tokens = math.Min(tb.burst,
+			tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	}
+	tb.last = now
+}
+
+// An internal method that takes n tokens if they are available.
+func (tb *tokenBucket) take(now time.Time, n int) bool {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.refill(now)
+	need := math.Min(float64(n), tb.burst)
+	if tb.tokens < need {
+		return false
+	}
+	tb.tokens -= float64(n)
+	return true
+}
+
+// An internal method that takes n tokens if they are available and
+// otherwise returns how long to wait before trying again.
+func (tb *tokenBucket) reserve(now time.Time, n int) (time.Duration, bool) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.refill(now)
+	need := math.Min(float64(n), tb.burst)
+	if tb.tokens >= need {
+		tb.tokens -= float64(n)
+		return 0, true
+	}
+	return time.Duration(math.Ceil((need - tb.tokens) / tb.rate *
+		float64(time.Second))), false
+}
+
+// An internal method that returns previously taken tokens.
+func (tb *tokenBucket) refund(n int) {
+	tb.lock.Lock()
+	tb.tokens = math.Min(tb.burst, tb.tokens+float64(n))
+	tb.lock.Unlock()
+}
+
+// An error struct used to represent a publish that was rejected because
+// a rate or concurrency limit was reached.
+type RateLimitError struct {
+	err string
+}
+
+// This function returns the message associated with the RateLimitError
+// error struct.
+func (e RateLimitError) Error() string {
+	return e.err
+}
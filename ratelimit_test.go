@@ -0,0 +1,209 @@
+//    ratelimit_test.go
+//    ~~~~~~~~~
+//    This module implements the RateLimiter tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRlTokenBucket(t *testing.T) {
+	now := time.Unix(1428374723, 0)
+	tb := newTokenBucket(2, 0)
+	assert.True(t, tb.take(now, 1))
+	assert.True(t, tb.take(now, 1))
+	assert.False(t, tb.take(now, 1))
+	delay, ok := tb.reserve(now, 1)
+	assert.False(t, ok)
+	assert.Equal(t, delay, 500*time.Millisecond)
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, tb.take(now, 1))
+}
+
+func TestRlTokenBucketDebt(t *testing.T) {
+	now := time.Unix(1428374723, 0)
+	tb := newTokenBucket(1, 2)
+	assert.True(t, tb.take(now, 5))
+	assert.False(t, tb.take(now.Add(2*time.Second), 1))
+	assert.True(t, tb.take(now.Add(4*time.Second), 1))
+}
+
+func TestRlFailFast(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1,
+		Mode: LimitFailFast})
+	release, err := rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	release()
+	_, err = rl.acquire(context.Background(), 1, nil)
+	_, ok := err.(*RateLimitError)
+	assert.True(t, ok)
+}
+
+func TestRlFailFastRefundsItems(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{ItemsPerSecond: 10,
+		RequestsPerSecond: 1, Mode: LimitFailFast})
+	release, err := rl.acquire(context.Background(), 5, nil)
+	assert.Nil(t, err)
+	release()
+	_, err = rl.acquire(context.Background(), 5, nil)
+	assert.NotNil(t, err)
+	assert.True(t, rl.items.take(rl.now(), 5))
+}
+
+func TestRlMaxInFlight(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{MaxInFlight: 1,
+		Mode: LimitFailFast})
+	release, err := rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, rl.InFlight(), 1)
+	_, err = rl.acquire(context.Background(), 1, nil)
+	assert.NotNil(t, err)
+	release()
+	assert.Equal(t, rl.InFlight(), 0)
+	release, err = rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	release()
+}
+
+func TestRlBlockContextDone(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{MaxInFlight: 1})
+	release, err := rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, err = rl.acquire(ctx, 1, nil)
+	assert.Equal(t, err, context.DeadlineExceeded)
+	assert.Equal(t, rl.QueueDepth(), 0)
+}
+
+func TestRlBlockRefundsTokens(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{ItemsPerSecond: 10,
+		RequestsPerSecond: 1})
+	release, err := rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	release()
+	ctx, cancel := context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, err = rl.acquire(ctx, 5, nil)
+	assert.Equal(t, err, context.DeadlineExceeded)
+	assert.True(t, rl.items.take(rl.now(), 9))
+
+	rl = NewRateLimiter(RateLimitConfig{ItemsPerSecond: 10,
+		RequestsPerSecond: 2, MaxInFlight: 1})
+	release, err = rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	defer release()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = rl.acquire(ctx, 5, nil)
+	assert.Equal(t, err, context.Canceled)
+	assert.True(t, rl.items.take(rl.now(), 9))
+	assert.True(t, rl.requests.take(rl.now(), 1))
+}
+
+func TestRlQueueOnlyCountsWaiters(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{ItemsPerSecond: 1, ItemBurst: 10,
+		Mode: LimitQueue, MaxQueue: 1})
+	assert.True(t, rl.items.take(rl.now(), 5))
+	ctx, cancel := context.WithCancel(context.Background())
+	waited := make(chan error)
+	go func() {
+		_, err := rl.acquire(ctx, 10, nil)
+		waited <- err
+	}()
+	for rl.QueueDepth() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	var depths []int
+	release, err := rl.acquire(context.Background(), 1,
+		func(depth int) {
+			depths = append(depths, depth)
+		})
+	assert.Nil(t, err)
+	release()
+	assert.Nil(t, depths)
+	assert.Equal(t, rl.QueueDepth(), 1)
+	cancel()
+	assert.Equal(t, <-waited, context.Canceled)
+	assert.Equal(t, rl.QueueDepth(), 0)
+}
+
+func TestRlBlockWaits(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 50})
+	start := time.Now()
+	for i := 0; i < 51; i++ {
+		release, err := rl.acquire(context.Background(), 1, nil)
+		assert.Nil(t, err)
+		release()
+	}
+	assert.True(t, time.Since(start) >= 15*time.Millisecond)
+}
+
+func TestRlQueueFull(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{MaxInFlight: 1, Mode: LimitQueue,
+		MaxQueue: 1})
+	release, err := rl.acquire(context.Background(), 1, nil)
+	assert.Nil(t, err)
+	done := make(chan error)
+	go func() {
+		release, err := rl.acquire(context.Background(), 1, nil)
+		if err == nil {
+			release()
+		}
+		done <- err
+	}()
+	for rl.QueueDepth() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = rl.acquire(context.Background(), 1, nil)
+	_, ok := err.(*RateLimitError)
+	assert.True(t, ok)
+	release()
+	assert.Nil(t, <-done)
+}
+
+func TestPccPublishRateLimited(t *testing.T) {
+	pubCallResults = nil
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	item := NewItem(formats, "", "")
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	pcc.SetRateLimiter(NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1,
+		Mode: LimitFailFast}))
+	assert.NotNil(t, pcc.RateLimiter())
+	assert.Nil(t, pcc.Publish("chan", item))
+	err := pcc.Publish("chan", item)
+	_, ok := err.(*RateLimitError)
+	assert.True(t, ok)
+	assert.Equal(t, len(pubCallResults), 3)
+}
+
+func TestPccPublishRateLimitedProbe(t *testing.T) {
+	formats := make([]Formatter, 0)
+	formats = append(formats, fmt1a)
+	item := NewItem(formats, "", "")
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = pubCallTestMethod
+	cb, clock := newTestBreaker(CircuitBreakerConfig{FailureThreshold: 1,
+		OpenDuration: time.Second})
+	cb.record(&PublishError{err: "error"})
+	clock.now = clock.now.Add(time.Second)
+	pcc.SetCircuitBreaker(cb)
+	pcc.SetRateLimiter(NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1,
+		RequestBurst: 1, Mode: LimitFailFast}))
+	pcc.RateLimiter().requests.take(time.Now(), 1)
+	assert.NotNil(t, pcc.Publish("chan", item))
+	assert.Equal(t, cb.State(), CircuitHalfOpen)
+	assert.Nil(t, cb.allow())
+}
@@ -0,0 +1,110 @@
+//    relay.go
+//    ~~~~~~~~~
+//    This module implements the EPCP relay handler.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+// Package relay implements an EPCP endpoint that re-publishes the items
+// it receives through a pubcontrol.Publisher, such as a PubControl
+// instance configured with the real endpoints and their credentials.
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"github.com/fanout/go-pubcontrol"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The default maximum size of a request body.
+const DefaultMaxBodySize = 10 << 20
+
+// The Handler struct is an http.Handler accepting EPCP publish requests
+// on any path ending in /publish/. The items of every request are
+// re-published in a single batch and the request is answered once the
+// publish has completed, with 200 on success and 502 if the publish
+// failed.
+type Handler struct {
+	publisher   pubcontrol.Publisher
+	lock        sync.RWMutex
+	auth        pubcontrol.AuthVerifier
+	maxBodySize int64
+}
+
+// Initialize this struct with the publisher that received items are
+// re-published through. Requests are accepted without authentication
+// until SetAuth is called.
+func NewHandler(publisher pubcontrol.Publisher) *Handler {
+	return &Handler{publisher: publisher, maxBodySize: DefaultMaxBodySize}
+}
+
+// Require requests to pass any of the specified verifiers. Calling this
+// method without verifiers disables authentication.
+func (h *Handler) SetAuth(verifiers ...pubcontrol.AuthVerifier) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(verifiers) == 0 {
+		h.auth = nil
+	} else {
+		h.auth = pubcontrol.VerifyAny(verifiers...)
+	}
+}
+
+// Set the maximum size of a request body in bytes. Larger requests are
+// answered with 413.
+func (h *Handler) SetMaxBodySize(size int64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.maxBodySize = size
+}
+
+// Handle a publish request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/publish/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.lock.RLock()
+	auth := h.auth
+	maxBodySize := h.maxBodySize
+	h.lock.RUnlock()
+	if auth != nil && !auth(r.Header.Get("Authorization")) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request Entity Too Large",
+				http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	parsed, err := pubcontrol.ParsePublishRequest(bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items := make([]pubcontrol.ChannelItem, 0, len(parsed))
+	for i := range parsed {
+		items = append(items, pubcontrol.ChannelItem{
+			Channel: parsed[i].Channel, Item: parsed[i].Item()})
+	}
+	if err := h.publisher.PublishBatch(r.Context(), items); err != nil {
+		http.Error(w, "Publish failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	io.WriteString(w, "Published\n")
+}
@@ -0,0 +1,135 @@
+//    relay_test.go
+//    ~~~~~~~~~
+//    This module implements the EPCP relay handler tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/fanout/go-pubcontrol"
+	"github.com/fanout/go-pubcontrol/pubcontroltest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testFormat struct{}
+
+func (f *testFormat) Name() string {
+	return "http-stream"
+}
+
+func (f *testFormat) Export() interface{} {
+	return map[string]interface{}{"content": "hello", "size": 12345678901}
+}
+
+type jsonObjectFormat struct{}
+
+func (f *jsonObjectFormat) Name() string {
+	return "json-object"
+}
+
+func (f *jsonObjectFormat) Export() interface{} {
+	return map[string]interface{}{"event": "update", "count": 2}
+}
+
+func TestRelayOtherFormats(t *testing.T) {
+	upstream := pubcontroltest.NewServer()
+	defer upstream.Close()
+	server := httptest.NewServer(NewHandler(
+		pubcontrol.NewPubControlClient(upstream.URL())))
+	defer server.Close()
+	pcc := pubcontrol.NewPubControlClient(server.URL)
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{&jsonObjectFormat{}},
+		"", "")
+	assert.Nil(t, pcc.Publish("chan", item))
+	upstream.AssertFormat(t, "chan", "json-object",
+		map[string]interface{}{"event": "update", "count": 2})
+}
+
+func TestRelayEndToEnd(t *testing.T) {
+	upstream := pubcontroltest.NewServer()
+	defer upstream.Close()
+	upstream.RequireJwt("realm", []byte("upstream-key"))
+	upstreamClient := pubcontrol.NewPubControlClient(upstream.URL())
+	upstreamClient.SetAuthJwt(map[string]interface{}{"iss": "realm"},
+		[]byte("upstream-key"))
+
+	handler := NewHandler(upstreamClient)
+	handler.SetAuth(pubcontrol.VerifyBearer("local-token"))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	pcc := pubcontrol.NewPubControlClient(server.URL + "/relay")
+	pcc.SetAuthBearer("local-token")
+	item := pubcontrol.NewItem([]pubcontrol.Formatter{&testFormat{}}, "1", "0")
+	assert.Nil(t, pcc.Publish("chan", item))
+	items := upstream.Items()
+	assert.Equal(t, len(items), 1)
+	assert.Equal(t, items[0].Id, "1")
+	assert.Equal(t, items[0].PrevId, "0")
+	upstream.AssertFormat(t, "chan", "http-stream",
+		map[string]interface{}{"content": "hello", "size": 12345678901})
+	assert.True(t, strings.Contains(string(upstream.Requests()[0].Body),
+		`"size":12345678901`))
+
+	pcc.SetAuthBearer("wrong")
+	err := pcc.Publish("chan", item)
+	assert.Equal(t, err.(*pubcontrol.PublishError).StatusCode(), 401)
+
+	upstream.Respond(pubcontroltest.Status(500, "broken"))
+	pcc.SetAuthBearer("local-token")
+	err = pcc.Publish("chan", item)
+	assert.Equal(t, err.(*pubcontrol.PublishError).StatusCode(), 502)
+	assert.True(t, strings.Contains(err.Error(), "broken"))
+}
+
+func TestRelayRequests(t *testing.T) {
+	publisher := pubcontroltest.NewRecordingPublisher()
+	handler := NewHandler(publisher)
+	handler.SetMaxBodySize(200)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, path,
+			strings.NewReader(body)))
+		return recorder
+	}
+	assert.Equal(t, serve("POST", "/other", "").Code, http.StatusNotFound)
+	assert.Equal(t, serve("GET", "/publish/", "").Code,
+		http.StatusMethodNotAllowed)
+	assert.Equal(t, serve("POST", "/publish/", "{").Code,
+		http.StatusBadRequest)
+	assert.Equal(t, serve("POST", "/publish/", "{}").Code,
+		http.StatusBadRequest)
+	assert.Equal(t, serve("POST", "/publish/",
+		`{"items": [{"http-stream": {}}]}`).Code, http.StatusBadRequest)
+	assert.Equal(t, serve("POST", "/publish/",
+		`{"items": [{"channel": "a"}]}`).Code, http.StatusBadRequest)
+	assert.Equal(t, serve("POST", "/publish/",
+		`{"items": [`+strings.Repeat(" ", 200)+`]}`).Code,
+		http.StatusRequestEntityTooLarge)
+	publisher.AssertItemCount(t, 0)
+
+	response := serve("POST", "/publish/", `{"items": [{"channel": "a", `+
+		`"ws-message": {"content": "x"}}, {"channel": "b", "id": "2", `+
+		`"http-stream": {"content": "y"}}]}`)
+	assert.Equal(t, response.Code, http.StatusOK)
+	assert.Equal(t, len(publisher.Items()), 2)
+	export, err := publisher.ItemsOn("b")[0].Export()
+	assert.Nil(t, err)
+	encoded, err := json.Marshal(export)
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded), `{"http-stream":{"content":"y"},"id":"2"}`)
+
+	publisher.FailNext(errors.New("failed"))
+	response = serve("POST", "/publish/",
+		`{"items": [{"channel": "a", "ws-message": {"content": "x"}}]}`)
+	assert.Equal(t, response.Code, http.StatusBadGateway)
+	assert.True(t, strings.Contains(response.Body.String(), "failed"))
+}
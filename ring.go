@@ -0,0 +1,120 @@
+//    ring.go
+//    ~~~~~~~~~
+//    This module implements the consistent hash ring used for sharding.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// The default number of virtual nodes per unit of client weight.
+const defaultVirtualNodes = 160
+
+// An internal struct representing a single virtual node on the ring.
+type ringPoint struct {
+	hash   uint64
+	client *PubControlClient
+}
+
+// An internal consistent hash ring mapping channels to clients. Each
+// client is placed on the ring at a number of points proportional to its
+// weight. The points of a client depend only on its key, so adding or
+// removing a client only moves the channels owned by that client.
+type hashRing struct {
+	points []ringPoint
+	labels map[*PubControlClient]string
+}
+
+// An internal function that builds a ring from the specified clients,
+// weights and number of virtual nodes per unit of weight. Clients are
+// keyed by their name, or their URI if they have no name. Clients sharing
+// the same key are distinguished by the order in which they appear.
+func newHashRing(clients []*PubControlClient,
+	weights map[*PubControlClient]int, virtualNodes int) *hashRing {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	ring := new(hashRing)
+	ring.labels = make(map[*PubControlClient]string)
+	seen := make(map[string]int)
+	for _, pcc := range clients {
+		label := pcc.label()
+		ring.labels[pcc] = label
+		key := label
+		if n := seen[label]; n > 0 {
+			key = key + "#" + strconv.Itoa(n)
+		}
+		seen[label]++
+		weight, ok := weights[pcc]
+		if !ok {
+			weight = 1
+		}
+		for i := 0; i < weight*virtualNodes; i++ {
+			ring.points = append(ring.points, ringPoint{
+				hash: hashKey(key + "-" + strconv.Itoa(i)), client: pcc})
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool {
+		return ring.points[i].hash < ring.points[j].hash
+	})
+	return ring
+}
+
+// An internal method that reports whether the name or URI of any client
+// has changed since the ring was built, in which case the ring has to be
+// rebuilt so that the client is keyed by its current label.
+func (r *hashRing) stale() bool {
+	for pcc, label := range r.labels {
+		if pcc.label() != label {
+			return true
+		}
+	}
+	return false
+}
+
+// An internal method that returns the client owning the specified channel.
+// Clients rejected by the accept function are skipped and the next client
+// on the ring is used instead. Nil is returned if no client is accepted.
+func (r *hashRing) lookup(channel string,
+	accept func(pcc *PubControlClient) bool) *PubControlClient {
+	if len(r.points) == 0 {
+		return nil
+	}
+	hash := hashKey(channel)
+	start := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	rejected := make(map[*PubControlClient]bool)
+	for i := 0; i < len(r.points); i++ {
+		pcc := r.points[(start+i)%len(r.points)].client
+		if rejected[pcc] {
+			continue
+		}
+		if accept == nil || accept(pcc) {
+			return pcc
+		}
+		rejected[pcc] = true
+	}
+	return nil
+}
+
+// An internal function that hashes a ring key. The FNV-1a hash is passed
+// through a finalizer so that keys differing only in their last characters
+// are spread evenly around the ring.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,174 @@
+//    ring_test.go
+//    ~~~~~~~~~
+//    This module implements the consistent hash ring tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func newRingTestClients(n int) []*PubControlClient {
+	clients := make([]*PubControlClient, 0, n)
+	for i := 0; i < n; i++ {
+		clients = append(clients, NewPubControlClient("uri"+strconv.Itoa(i)))
+	}
+	return clients
+}
+
+func TestRingLookupEmpty(t *testing.T) {
+	ring := newHashRing(nil, nil, 0)
+	assert.Nil(t, ring.lookup("chan", nil))
+}
+
+func TestRingDistribution(t *testing.T) {
+	clients := newRingTestClients(4)
+	ring := newHashRing(clients, nil, 0)
+	counts := make(map[*PubControlClient]int)
+	for i := 0; i < 10000; i++ {
+		counts[ring.lookup("chan"+strconv.Itoa(i), nil)]++
+	}
+	for _, pcc := range clients {
+		assert.True(t, counts[pcc] > 1500, "uneven share %d", counts[pcc])
+		assert.True(t, counts[pcc] < 3500, "uneven share %d", counts[pcc])
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	clients := newRingTestClients(2)
+	ring := newHashRing(clients, map[*PubControlClient]int{clients[0]: 3}, 0)
+	counts := make(map[*PubControlClient]int)
+	for i := 0; i < 10000; i++ {
+		counts[ring.lookup("chan"+strconv.Itoa(i), nil)]++
+	}
+	assert.True(t, counts[clients[0]] > 2*counts[clients[1]])
+}
+
+func TestRingMinimalReshuffle(t *testing.T) {
+	clients := newRingTestClients(4)
+	before := newHashRing(clients[:3], nil, 0)
+	after := newHashRing(clients, nil, 0)
+	for i := 0; i < 10000; i++ {
+		channel := "chan" + strconv.Itoa(i)
+		owner := after.lookup(channel, nil)
+		if owner != clients[3] {
+			assert.Equal(t, before.lookup(channel, nil), owner)
+		}
+	}
+}
+
+func TestRingDuplicateKeys(t *testing.T) {
+	clients := []*PubControlClient{NewPubControlClient("uri"),
+		NewPubControlClient("uri")}
+	ring := newHashRing(clients, nil, 0)
+	counts := make(map[*PubControlClient]int)
+	for i := 0; i < 1000; i++ {
+		counts[ring.lookup("chan"+strconv.Itoa(i), nil)]++
+	}
+	assert.Equal(t, len(counts), 2)
+}
+
+func TestPcShardingRename(t *testing.T) {
+	clients := newRingTestClients(3)
+	pc := NewPubControl(nil)
+	for _, pcc := range clients {
+		pcc.pubCall = pubCallTestMethod
+		pc.AddClient(pcc)
+	}
+	pc.EnableSharding(0)
+	clients[0].SetName("renamed")
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.False(t, pc.ring.stale())
+	expected := newHashRing(clients, nil, 0)
+	for i := 0; i < 100; i++ {
+		channel := "chan" + strconv.Itoa(i)
+		assert.True(t, pc.ring.lookup(channel, nil) ==
+			expected.lookup(channel, nil))
+	}
+}
+
+func TestRingLookupAccept(t *testing.T) {
+	clients := newRingTestClients(3)
+	ring := newHashRing(clients, nil, 0)
+	owner := ring.lookup("chan", nil)
+	other := ring.lookup("chan", func(pcc *PubControlClient) bool {
+		return pcc != owner
+	})
+	assert.NotNil(t, other)
+	assert.NotEqual(t, other, owner)
+	assert.Nil(t, ring.lookup("chan", func(pcc *PubControlClient) bool {
+		return false
+	}))
+}
+
+func TestPcPublishSharded(t *testing.T) {
+	var lock sync.Mutex
+	received := make(map[string][]string)
+	recorder := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, entry := range items {
+			received[entry.Channel] = append(received[entry.Channel], pcc.uri)
+		}
+		return nil
+	}
+	pc := NewPubControl(nil)
+	for _, pcc := range newRingTestClients(3) {
+		pcc.publish = recorder
+		pc.AddClient(pcc)
+	}
+	pcc := NewPubControlClient("weighted")
+	pcc.publish = recorder
+	pc.AddWeightedClient(pcc, 2)
+	pc.EnableSharding(0)
+	items := make([]ChannelItem, 0)
+	for i := 0; i < 100; i++ {
+		items = append(items, ChannelItem{Channel: "chan" + strconv.Itoa(i),
+			Item: newPolicyTestItem()})
+	}
+	assert.Nil(t, pc.PublishBatch(context.Background(), items))
+	assert.Equal(t, len(received), 100)
+	owners := make(map[string]bool)
+	for _, uris := range received {
+		assert.Equal(t, len(uris), 1)
+		owners[uris[0]] = true
+	}
+	assert.Equal(t, len(owners), 4)
+	pc.DisableSharding()
+	received = make(map[string][]string)
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, len(received["chan"]), 4)
+}
+
+func TestPcPublishShardedError(t *testing.T) {
+	pc := NewPubControl(nil)
+	pcc := NewPubControlClient("errorUri")
+	pcc.publish = publishError
+	pc.AddClient(pcc)
+	pc.EnableSharding(10)
+	err := pc.Publish("chan", newPolicyTestItem())
+	assert.Equal(t, "1/1 client(s) failed to publish to channel: chan "+
+		"Errors: [errorUri: Intentional error for tests]", err.Error())
+}
+
+func TestPcApplyConfigWeight(t *testing.T) {
+	pc := NewPubControl(nil)
+	pc.EnableSharding(0)
+	pc.ApplyConfig([]map[string]interface{}{
+		map[string]interface{}{"uri": "uri", "weight": float64(3)},
+		map[string]interface{}{"uri": "uri2"}})
+	assert.Equal(t, pc.weights[pc.clients[0]], 3)
+	assert.Equal(t, len(pc.ring.points), 4*defaultVirtualNodes)
+	pc.SetClientWeight(pc.clients[1], 2)
+	assert.Equal(t, len(pc.ring.points), 5*defaultVirtualNodes)
+	pc.RemoveAllClients()
+	assert.Equal(t, len(pc.ring.points), 0)
+}
@@ -0,0 +1,139 @@
+//    route.go
+//    ~~~~~~~~~
+//    This module implements the Route and ChannelFilter functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A ChannelFilter function reports whether the specified item published to
+// the specified channel matches the filter.
+type ChannelFilter func(channel string, item *Item) bool
+
+// The Route struct determines which channels a PubControlClient instance
+// receives when publishing through a PubControl instance. A channel is
+// routed to the client if it matches at least one Include filter, or if
+// there are no Include filters, and it matches none of the Exclude filters.
+type Route struct {
+	Include []ChannelFilter
+	Exclude []ChannelFilter
+}
+
+// An internal method that reports whether the specified item published to
+// the specified channel should be sent to the client using this route. A
+// nil route matches everything.
+func (r *Route) matches(channel string, item *Item) bool {
+	if r == nil {
+		return true
+	}
+	included := len(r.Include) == 0
+	for _, filter := range r.Include {
+		if filter(channel, item) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, filter := range r.Exclude {
+		if filter(channel, item) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get a ChannelFilter that matches channels starting with any of the
+// specified prefixes.
+func ChannelPrefix(prefixes ...string) ChannelFilter {
+	return func(channel string, item *Item) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(channel, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Get a ChannelFilter that matches channels matching any of the specified
+// glob patterns. The pattern syntax is that of path.Match. Malformed
+// patterns never match.
+func ChannelGlob(patterns ...string) ChannelFilter {
+	return func(channel string, item *Item) bool {
+		for _, pattern := range patterns {
+			if matched, err := path.Match(pattern, channel); err == nil &&
+				matched {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Get a ChannelFilter that matches channels matching the specified regular
+// expression.
+func ChannelRegexp(re *regexp.Regexp) ChannelFilter {
+	return func(channel string, item *Item) bool {
+		return re.MatchString(channel)
+	}
+}
+
+// An internal function that builds a Route from the 'include' and
+// 'exclude' glob pattern lists of a configuration entry. Nil is returned
+// if the entry contains neither.
+func routeFromConfig(entry map[string]interface{}) *Route {
+	include := stringList(entry["include"])
+	exclude := stringList(entry["exclude"])
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	route := new(Route)
+	if len(include) > 0 {
+		route.Include = []ChannelFilter{ChannelGlob(include...)}
+	}
+	if len(exclude) > 0 {
+		route.Exclude = []ChannelFilter{ChannelGlob(exclude...)}
+	}
+	return route
+}
+
+// An internal function that converts a configuration value holding either
+// a single string or a list of strings into a slice of strings.
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []string:
+		return value
+	case []interface{}:
+		out := make([]string, 0, len(value))
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// An error struct passed to listeners for items that were dropped because
+// no client route matched their channel.
+type UnroutedError struct {
+	err string
+}
+
+// This function returns the message associated with the UnroutedError
+// error struct.
+func (e UnroutedError) Error() string {
+	return e.err
+}
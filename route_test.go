@@ -0,0 +1,111 @@
+//    route_test.go
+//    ~~~~~~~~~
+//    This module implements the Route and ChannelFilter tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"sync"
+	"testing"
+)
+
+func TestChannelFilters(t *testing.T) {
+	prefix := ChannelPrefix("a-", "b-")
+	assert.True(t, prefix("a-chan", nil))
+	assert.True(t, prefix("b-chan", nil))
+	assert.False(t, prefix("c-chan", nil))
+	glob := ChannelGlob("tenant1.*", "[")
+	assert.True(t, glob("tenant1.chan", nil))
+	assert.False(t, glob("tenant2.chan", nil))
+	assert.False(t, glob("[", nil))
+	re := ChannelRegexp(regexp.MustCompile("^t[0-9]+$"))
+	assert.True(t, re("t12", nil))
+	assert.False(t, re("t12x", nil))
+}
+
+func TestRouteMatches(t *testing.T) {
+	var route *Route
+	assert.True(t, route.matches("chan", nil))
+	route = &Route{Exclude: []ChannelFilter{ChannelPrefix("private-")}}
+	assert.True(t, route.matches("chan", nil))
+	assert.False(t, route.matches("private-chan", nil))
+	route.Include = []ChannelFilter{ChannelPrefix("a"), ChannelPrefix("p")}
+	assert.False(t, route.matches("chan", nil))
+	assert.True(t, route.matches("a-chan", nil))
+	assert.False(t, route.matches("private-chan", nil))
+	item := NewItem(nil, "skip", "")
+	route = &Route{Exclude: []ChannelFilter{func(channel string,
+		item *Item) bool {
+		return item.id == "skip"
+	}}}
+	assert.False(t, route.matches("chan", item))
+}
+
+func TestRouteFromConfig(t *testing.T) {
+	assert.Nil(t, routeFromConfig(map[string]interface{}{"uri": "uri"}))
+	route := routeFromConfig(map[string]interface{}{"uri": "uri",
+		"include": []interface{}{"a.*", "b.*"}, "exclude": "a.private"})
+	assert.True(t, route.matches("b.chan", nil))
+	assert.False(t, route.matches("a.private", nil))
+	assert.False(t, route.matches("c.chan", nil))
+}
+
+func TestPcPublishRoutes(t *testing.T) {
+	var lock sync.Mutex
+	received := make(map[string][]string)
+	recorder := func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		lock.Lock()
+		defer lock.Unlock()
+		for _, entry := range items {
+			received[pcc.uri] = append(received[pcc.uri], entry.Channel)
+		}
+		return nil
+	}
+	pc := NewPubControl(nil)
+	pcc1 := NewPubControlClient("uri1")
+	pcc1.publish = recorder
+	pc.AddClientWithRoute(pcc1, &Route{
+		Include: []ChannelFilter{ChannelPrefix("tenant1.")}})
+	pcc2 := NewPubControlClient("uri2")
+	pcc2.publish = recorder
+	pc.AddClient(pcc2)
+	pc.SetClientRoute(pcc2, &Route{
+		Include: []ChannelFilter{ChannelPrefix("tenant2.")}})
+	pcc3 := NewPubControlClient("uri3")
+	pcc3.publish = recorder
+	pc.AddClient(pcc3)
+	item := newPolicyTestItem()
+	err := pc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "tenant1.a", Item: item}, {Channel: "tenant2.a", Item: item},
+		{Channel: "tenant1.b", Item: item}})
+	assert.Nil(t, err)
+	assert.Equal(t, received["uri1"], []string{"tenant1.a", "tenant1.b"})
+	assert.Equal(t, received["uri2"], []string{"tenant2.a"})
+	assert.Equal(t, received["uri3"], []string{"tenant1.a", "tenant2.a",
+		"tenant1.b"})
+	pc.SetClientRoute(pcc3, &Route{
+		Exclude: []ChannelFilter{ChannelGlob("*")}})
+	received = make(map[string][]string)
+	pcc1.publish = publishError
+	err = pc.Publish("tenant1.a", item)
+	assert.Equal(t, "1/1 client(s) failed to publish to channel: tenant1.a "+
+		"Errors: [uri1: Intentional error for tests]", err.Error())
+	assert.Equal(t, len(received), 0)
+}
+
+func TestPcApplyConfigRoutes(t *testing.T) {
+	pc := NewPubControl([]map[string]interface{}{
+		map[string]interface{}{"uri": "uri", "include": "a.*"},
+		map[string]interface{}{"uri": "uri2"}})
+	assert.NotNil(t, pc.routes[pc.clients[0]])
+	assert.Nil(t, pc.routes[pc.clients[1]])
+	pc.RemoveAllClients()
+	assert.Equal(t, len(pc.routes), 0)
+}
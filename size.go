@@ -0,0 +1,283 @@
+//    size.go
+//    ~~~~~~~~~
+//    This module implements the payload size limits of PubControlClient.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// The JSON that wraps the items of a publish request.
+const (
+	requestPrefix = `{"items":[`
+	requestSuffix = `]}`
+)
+
+// Set the maximum size in bytes of the JSON encoding of a single item,
+// including its channel. Publishing an item that is larger fails with an
+// ItemSizeError before the circuit breaker and rate limiter are consulted
+// and before anything is sent. The limit applies to every transport.
+// Zero disables the limit.
+func (pcc *PubControlClient) SetMaxItemSize(size int) {
+	pcc.lock.Lock()
+	pcc.maxItemSize = size
+	pcc.lock.Unlock()
+}
+
+// Get the maximum size of a single item or zero if there is no limit.
+func (pcc *PubControlClient) MaxItemSize() int {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.maxItemSize
+}
+
+// Set the maximum size in bytes of the body of a publish request. Batches
+// that are larger are split into several requests under the limit, which
+// are made in order and each of which is subject to the rate limiter. If
+// one of them fails then a PartialPublishError listing the items of the
+// earlier requests is returned, so that they are reported as delivered
+// and not published again by PolicyFailover. Publishing an item that does
+// not fit into a request by itself fails with an ItemSizeError like an
+// item exceeding the maximum item size. The limit applies to every
+// transport, with the size computed from the JSON encoding of the items.
+// Zero disables the limit.
+func (pcc *PubControlClient) SetMaxRequestSize(size int) {
+	pcc.lock.Lock()
+	pcc.maxRequestSize = size
+	pcc.lock.Unlock()
+}
+
+// Get the maximum size of a publish request or zero if there is no limit.
+func (pcc *PubControlClient) MaxRequestSize() int {
+	pcc.lock.Lock()
+	defer pcc.lock.Unlock()
+	return pcc.maxRequestSize
+}
+
+// An internal struct holding the JSON body of a publish request and the
+// number of items it contains.
+type publishRequest struct {
+	content []byte
+	count   int
+}
+
+// An internal function that encodes each of the specified exported items
+// as JSON. An ItemSizeError is returned if any item exceeds the limits.
+func encodeExports(exports []map[string]interface{}, maxItemSize,
+	maxRequestSize int) ([][]byte, error) {
+	encoded := make([][]byte, 0, len(exports))
+	for _, export := range exports {
+		itemJson, err := json.Marshal(export)
+		if err != nil {
+			return nil, err
+		}
+		err = checkItemSize(export, len(itemJson), maxItemSize,
+			maxRequestSize)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, itemJson)
+	}
+	return encoded, nil
+}
+
+// An internal function that exports the specified items, including their
+// channels, and encodes each of them as JSON, so that oversized items fail
+// before the circuit breaker and rate limiter are consulted, whatever the
+// transport. An ItemSizeError is returned if any item exceeds the limits.
+func encodeItems(items []ChannelItem, maxItemSize,
+	maxRequestSize int) ([][]byte, error) {
+	exports := make([]map[string]interface{}, 0, len(items))
+	for _, entry := range items {
+		export, err := entry.Item.Export()
+		if err != nil {
+			return nil, err
+		}
+		export["channel"] = entry.Channel
+		exports = append(exports, export)
+	}
+	return encodeExports(exports, maxItemSize, maxRequestSize)
+}
+
+// An internal function that returns the number of items in each of as
+// few publish requests as possible holding the specified encoded items
+// without exceeding the maximum request size. The order of the items is
+// kept.
+func splitCounts(encoded [][]byte, maxRequestSize int) []int {
+	counts := make([]int, 0, 1)
+	overhead := len(requestPrefix) + len(requestSuffix)
+	size := overhead
+	count := 0
+	for _, itemJson := range encoded {
+		if count > 0 && maxRequestSize > 0 &&
+			size+1+len(itemJson) > maxRequestSize {
+			counts = append(counts, count)
+			size = overhead
+			count = 0
+		}
+		if count > 0 {
+			size++
+		}
+		size += len(itemJson)
+		count++
+	}
+	return append(counts, count)
+}
+
+// An internal function that joins the specified encoded items into the
+// JSON bodies of as few publish requests as possible without exceeding
+// the maximum request size.
+func splitRequests(encoded [][]byte, maxRequestSize int) []publishRequest {
+	counts := splitCounts(encoded, maxRequestSize)
+	requests := make([]publishRequest, 0, len(counts))
+	for _, count := range counts {
+		content := make([]byte, 0, len(requestPrefix)+len(requestSuffix))
+		content = append(content, requestPrefix...)
+		content = append(content, bytes.Join(encoded[:count], []byte{','})...)
+		content = append(content, requestSuffix...)
+		requests = append(requests, publishRequest{content: content,
+			count: count})
+		encoded = encoded[count:]
+	}
+	return requests
+}
+
+// An internal type used as the context key for the items encoded by
+// publishBatch.
+type encodedItemsContextKey struct{}
+
+// An internal struct holding items along with their JSON encodings.
+type encodedItems struct {
+	items   []ChannelItem
+	encoded [][]byte
+}
+
+// An internal function that returns a context carrying the JSON encodings
+// of the specified items, so that the HTTP transport does not have to
+// encode them again.
+func withEncodedItems(ctx context.Context, items []ChannelItem,
+	encoded [][]byte) context.Context {
+	return context.WithValue(ctx, encodedItemsContextKey{},
+		encodedItems{items: items, encoded: encoded})
+}
+
+// An internal function that returns the JSON encodings carried by the
+// context if they were made for the specified items, or nil otherwise.
+func encodedItemsFromContext(ctx context.Context,
+	items []ChannelItem) [][]byte {
+	value, ok := ctx.Value(encodedItemsContextKey{}).(encodedItems)
+	if !ok || len(value.items) != len(items) {
+		return nil
+	}
+	for i, entry := range items {
+		if value.items[i] != entry {
+			return nil
+		}
+	}
+	return value.encoded
+}
+
+// An internal function that returns an ItemSizeError if the specified
+// exported item, whose JSON encoding has the specified size, exceeds the
+// maximum item size or does not fit into a request by itself.
+func checkItemSize(item map[string]interface{}, size, maxItemSize,
+	maxRequestSize int) error {
+	if maxItemSize > 0 && size > maxItemSize {
+		return newItemSizeError(item, size, "item", maxItemSize)
+	}
+	overhead := len(requestPrefix) + len(requestSuffix)
+	if maxRequestSize > 0 && size+overhead > maxRequestSize {
+		return newItemSizeError(item, size+overhead, "request",
+			maxRequestSize)
+	}
+	return nil
+}
+
+// An internal function that reports whether the specified error was
+// caused by the items being published rather than by the endpoint.
+func isItemError(err error) bool {
+	switch err.(type) {
+	case *ItemFormatError, *ItemSizeError:
+		return true
+	}
+	return false
+}
+
+// An error struct used to represent an item that exceeds the maximum item
+// or request size.
+type ItemSizeError struct {
+	err   string
+	size  int
+	limit int
+}
+
+// An internal function that creates an ItemSizeError for the specified
+// exported item.
+func newItemSizeError(item map[string]interface{}, size int, kind string,
+	limit int) *ItemSizeError {
+	channel, _ := item["channel"].(string)
+	return &ItemSizeError{err: "Item for channel " + strconv.Quote(channel) +
+		" is " + strconv.Itoa(size) + " bytes, which exceeds the maximum " +
+		kind + " size of " + strconv.Itoa(limit) + " bytes",
+		size: size, limit: limit}
+}
+
+// Get the size in bytes of the item, or of the request consisting of only
+// the item if the maximum request size was exceeded.
+func (e ItemSizeError) Size() int {
+	return e.size
+}
+
+// Get the limit in bytes that was exceeded.
+func (e ItemSizeError) Limit() int {
+	return e.limit
+}
+
+// This function returns the message associated with the ItemSizeError
+// error struct.
+func (e ItemSizeError) Error() string {
+	return e.err
+}
+
+// An error struct used to represent a publish that was split into several
+// requests of which only the first ones succeeded. The items of the
+// successful requests were delivered and are not retried.
+type PartialPublishError struct {
+	err       string
+	count     int
+	delivered []ChannelItem
+	cause     error
+}
+
+// An internal function that creates a PartialPublishError for a publish
+// of the specified number of items that failed with the specified error
+// after delivering the first count items.
+func newPartialPublishError(count, total int,
+	cause error) *PartialPublishError {
+	return &PartialPublishError{err: "Published " + strconv.Itoa(count) +
+		" of " + strconv.Itoa(total) + " items before failing: " +
+		cause.Error(), count: count, cause: cause}
+}
+
+// Get the items that were delivered before the publish failed.
+func (e PartialPublishError) Delivered() []ChannelItem {
+	return e.delivered
+}
+
+// Get the error that caused the remaining items to fail.
+func (e PartialPublishError) Unwrap() error {
+	return e.cause
+}
+
+// This function returns the message associated with the
+// PartialPublishError error struct.
+func (e PartialPublishError) Error() string {
+	return e.err
+}
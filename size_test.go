@@ -0,0 +1,330 @@
+//    size_test.go
+//    ~~~~~~~~~
+//    This module implements the payload size limit tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func sizeTestItems(channels ...string) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(channels))
+	for _, channel := range channels {
+		items = append(items, map[string]interface{}{"channel": channel})
+	}
+	return items
+}
+
+func encodeSizeTestItems(t *testing.T, channels ...string) [][]byte {
+	encoded, err := encodeExports(sizeTestItems(channels...), 0, 0)
+	assert.Nil(t, err)
+	return encoded
+}
+
+func TestSplitRequests(t *testing.T) {
+	// Every item is {"channel":"x"}, which is 15 bytes.
+	encoded := encodeSizeTestItems(t, "a", "b", "c")
+	requests := splitRequests(encoded, 0)
+	expected, _ := json.Marshal(map[string]interface{}{
+		"items": sizeTestItems("a", "b", "c")})
+	assert.Equal(t, requests, []publishRequest{{content: expected, count: 3}})
+
+	requests = splitRequests(encoded, 44)
+	assert.Equal(t, len(requests), 2)
+	assert.Equal(t, string(requests[0].content),
+		`{"items":[{"channel":"a"},{"channel":"b"}]}`)
+	assert.Equal(t, requests[0].count, 2)
+	assert.Equal(t, string(requests[1].content), `{"items":[{"channel":"c"}]}`)
+	assert.Equal(t, requests[1].count, 1)
+
+	requests = splitRequests(encoded, 27)
+	assert.Equal(t, len(requests), 3)
+
+	requests = splitRequests(nil, 27)
+	assert.Equal(t, requests, []publishRequest{
+		{content: []byte(`{"items":[]}`), count: 0}})
+}
+
+func TestEncodeExportsItemTooLarge(t *testing.T) {
+	items := sizeTestItems("a", "large")
+	_, err := encodeExports(items, 15, 0)
+	sizeErr, ok := err.(*ItemSizeError)
+	assert.True(t, ok)
+	assert.Equal(t, sizeErr.Size(), 19)
+	assert.Equal(t, sizeErr.Limit(), 15)
+	assert.Equal(t, sizeErr.Error(), `Item for channel "large" is 19 bytes, `+
+		`which exceeds the maximum item size of 15 bytes`)
+
+	_, err = encodeExports(items, 0, 30)
+	sizeErr, ok = err.(*ItemSizeError)
+	assert.True(t, ok)
+	assert.Equal(t, sizeErr.Size(), 31)
+	assert.True(t, strings.Contains(sizeErr.Error(), "maximum request size"))
+}
+
+func TestEncodedItemsContext(t *testing.T) {
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	items := []ChannelItem{{Channel: "a", Item: item}}
+	encoded, err := encodeItems(items, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded[0]),
+		`{"channel":"a","test-format":"value1a"}`)
+	ctx := withEncodedItems(context.Background(), items, encoded)
+	assert.Equal(t, encodedItemsFromContext(ctx, items), encoded)
+	assert.Nil(t, encodedItemsFromContext(ctx,
+		[]ChannelItem{{Channel: "b", Item: item}}))
+	assert.Nil(t, encodedItemsFromContext(ctx, nil))
+	assert.Nil(t, encodedItemsFromContext(context.Background(), items))
+}
+
+type countingFormat struct {
+	exports int
+}
+
+func (f *countingFormat) Name() string {
+	return "test-format"
+}
+
+func (f *countingFormat) Export() interface{} {
+	f.exports++
+	return "value"
+}
+
+func TestPccPublishEncodesOnce(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	var bodies []string
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		bodies = append(bodies, string(jsonContent))
+		return 200, nil, nil
+	}
+	pcc.SetMaxRequestSize(1000)
+	format := &countingFormat{}
+	assert.Nil(t, pcc.Publish("chan", NewItem([]Formatter{format}, "", "")))
+	assert.Equal(t, format.exports, 1)
+	assert.Equal(t, bodies, []string{
+		`{"items":[{"channel":"chan","test-format":"value"}]}`})
+}
+
+func TestPccPubCallSplit(t *testing.T) {
+	makeHttpRequestResults = nil
+	pcc := NewPubControlClient("uri")
+	pcc.makeHttpRequest = makeHttpRequestTestMethod
+	pcc.SetMaxRequestSize(44)
+	assert.Equal(t, pcc.MaxRequestSize(), 44)
+	err := pcc.pubCall(pcc, context.Background(), "http://uri.com", "auth",
+		sizeTestItems("a", "b", "c"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(makeHttpRequestResults), 6)
+	assert.Equal(t, makeHttpRequestResults[0], "http://uri.com/publish/")
+	assert.Equal(t, string(makeHttpRequestResults[2].([]byte)),
+		`{"items":[{"channel":"a"},{"channel":"b"}]}`)
+	assert.Equal(t, makeHttpRequestResults[4], "auth")
+	assert.Equal(t, string(makeHttpRequestResults[5].([]byte)),
+		`{"items":[{"channel":"c"}]}`)
+
+	calls := 0
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		calls++
+		return 413, nil, nil
+	}
+	err = pcc.pubCall(pcc, context.Background(), "http://uri.com", "",
+		sizeTestItems("a", "b", "c"))
+	publishErr, ok := err.(*PublishError)
+	assert.True(t, ok)
+	assert.Equal(t, publishErr.StatusCode(), 413)
+	assert.Equal(t, calls, 1)
+
+	calls = 0
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		calls++
+		if calls > 1 {
+			return 500, []byte("down"), nil
+		}
+		return 200, nil, nil
+	}
+	err = pcc.pubCall(pcc, context.Background(), "http://uri.com", "",
+		sizeTestItems("a", "b", "c"))
+	partial, ok := err.(*PartialPublishError)
+	assert.True(t, ok)
+	assert.Equal(t, partial.count, 2)
+	assert.Equal(t, partial.Error(), "Published 2 of 3 items before "+
+		"failing: Failure status code: 500 with message: down")
+	assert.True(t, errors.As(err, &publishErr))
+	assert.Equal(t, publishErr.StatusCode(), 500)
+}
+
+// Returns a client that publishes every item with its own request, the
+// first of which succeeds while the others fail.
+func newPartialTestClient() *PubControlClient {
+	pcc := NewPubControlClient("uri")
+	pcc.SetMaxRequestSize(60)
+	calls := 0
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		calls++
+		if calls > 1 {
+			return 500, nil, nil
+		}
+		return 200, nil, nil
+	}
+	return pcc
+}
+
+func TestPccPublishPartial(t *testing.T) {
+	pcc := newPartialTestClient()
+	metrics := &testMetrics{}
+	pcc.SetMetrics(metrics)
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	items := []ChannelItem{{Channel: "a", Item: item},
+		{Channel: "b", Item: item}, {Channel: "c", Item: item}}
+	err := pcc.PublishBatch(context.Background(), items)
+	partial, ok := err.(*PartialPublishError)
+	assert.True(t, ok)
+	assert.Equal(t, partial.Delivered(), items[:1])
+	assert.Equal(t, metrics.items, []int{1, 2})
+	assert.Equal(t, metrics.errs, []error{nil, err})
+}
+
+func TestPccPublishSplitRateLimited(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.SetMaxRequestSize(60)
+	requests := 0
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		requests++
+		return 200, nil, nil
+	}
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1})
+	pcc.SetCircuitBreaker(breaker)
+	pcc.SetRateLimiter(NewRateLimiter(RateLimitConfig{RequestsPerSecond: 0.001,
+		RequestBurst: 1, Mode: LimitFailFast}))
+	listener := &testListener{}
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	items := []ChannelItem{{Channel: "a", Item: item},
+		{Channel: "b", Item: item}, {Channel: "c", Item: item}}
+	err := pcc.PublishBatch(withListener(context.Background(), listener),
+		items)
+	partial, ok := err.(*PartialPublishError)
+	assert.True(t, ok)
+	assert.Equal(t, partial.Delivered(), items[:1])
+	_, ok = partial.Unwrap().(*RateLimitError)
+	assert.True(t, ok)
+	assert.Equal(t, requests, 1)
+	assert.Equal(t, len(listener.successes), 1)
+	assert.Equal(t, len(listener.drops), 2)
+	assert.Equal(t, len(listener.failures), 0)
+	assert.Equal(t, breaker.State(), CircuitClosed)
+}
+
+func TestPcFailoverPartial(t *testing.T) {
+	first := newPartialTestClient()
+	second := NewPubControlClient("uri2")
+	var received []string
+	second.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		received = append(received, string(jsonContent))
+		return 200, nil, nil
+	}
+	listener := &testListener{}
+	pc := NewPubControl(nil)
+	pc.AddClient(first)
+	pc.AddClient(second)
+	pc.SetPublishPolicy(PolicyFailover())
+	pc.SetListener(listener)
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	assert.Nil(t, pc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: item}, {Channel: "b", Item: item},
+		{Channel: "c", Item: item}}))
+	assert.Equal(t, received, []string{`{"items":[` +
+		`{"channel":"b","test-format":"value1a"},` +
+		`{"channel":"c","test-format":"value1a"}]}`})
+	channels := func(events []PublishEvent) []string {
+		names := make([]string, 0)
+		for _, event := range events {
+			names = append(names, event.Channel+"@"+event.Client.Uri())
+		}
+		return names
+	}
+	assert.Equal(t, channels(listener.successes),
+		[]string{"a@uri", "b@uri2", "c@uri2"})
+	assert.Equal(t, channels(listener.failures), []string{"b@uri", "c@uri"})
+	assert.Equal(t, channels(listener.retries), []string{"b@uri2", "c@uri2"})
+}
+
+func TestPccPublishItemTooLarge(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	calls := 0
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		calls++
+		return 200, nil, nil
+	}
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1})
+	pcc.SetCircuitBreaker(breaker)
+	pcc.SetMaxItemSize(40)
+	assert.Equal(t, pcc.MaxItemSize(), 40)
+	small := NewItem([]Formatter{fmt1a}, "", "")
+	large := NewItem([]Formatter{&FormatTestStruct1{
+		value: strings.Repeat("x", 100)}}, "", "")
+	err := pcc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: small}, {Channel: "b", Item: large}})
+	_, ok := err.(*ItemSizeError)
+	assert.True(t, ok)
+	assert.Equal(t, calls, 0)
+	assert.Equal(t, breaker.State(), CircuitClosed)
+	assert.Nil(t, pcc.Publish("a", small))
+	assert.Equal(t, calls, 1)
+}
+
+func TestApplyConfigSizeLimits(t *testing.T) {
+	pc := NewPubControl([]map[string]interface{}{
+		map[string]interface{}{"uri": "uri", "max_item_size": 1000,
+			"max_request_size": float64(10000)}})
+	assert.Equal(t, pc.clients[0].MaxItemSize(), 1000)
+	assert.Equal(t, pc.clients[0].MaxRequestSize(), 10000)
+}
+
+func TestPccSizeLimitsBeforeLimiter(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	var sent []ChannelItem
+	pcc.SetTransport(TransportFunc(func(ctx context.Context,
+		items []ChannelItem) error {
+		sent = append(sent, items...)
+		return nil
+	}))
+	pcc.SetRateLimiter(NewRateLimiter(RateLimitConfig{ItemsPerSecond: 0.001,
+		ItemBurst: 1, Mode: LimitFailFast}))
+	listener := &testListener{}
+	pcc.SetMaxItemSize(40)
+	large := NewItem([]Formatter{&FormatTestStruct1{
+		value: strings.Repeat("x", 100)}}, "", "")
+	err := pcc.PublishBatch(withListener(context.Background(), listener),
+		[]ChannelItem{{Channel: "a", Item: large}})
+	_, ok := err.(*ItemSizeError)
+	assert.True(t, ok)
+	assert.Equal(t, len(listener.drops), 1)
+	assert.Nil(t, sent)
+
+	pcc.SetMaxItemSize(0)
+	pcc.SetMaxRequestSize(60)
+	err = pcc.Publish("a", large)
+	_, ok = err.(*ItemSizeError)
+	assert.True(t, ok)
+	assert.Nil(t, sent)
+
+	small := NewItem([]Formatter{fmt1a}, "", "")
+	assert.Nil(t, pcc.Publish("a", small))
+	assert.Equal(t, sent, []ChannelItem{{Channel: "a", Item: small}})
+}
@@ -0,0 +1,69 @@
+//    item.go
+//    ~~~~~~~~~
+//    This module implements the conversion of EPCP items to tnetstrings.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package tnetstring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Convert an item in the EPCP JSON shape, such as the result of
+// pubcontrol.Item.Export, into the shape Pushpin expects in tnetstrings.
+// The item is normalized through JSON, so that formats can be any value
+// that marshals to a JSON object, and the base64 content of every '-bin'
+// field of a format is decoded into the field without the suffix, for
+// example 'content-bin' into 'content'. A TypeError is returned if the
+// item cannot be marshalled or a '-bin' field is not valid base64.
+func ConvertItem(item map[string]interface{}) (map[string]interface{},
+	error) {
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return nil, &TypeError{err: err.Error()}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var converted map[string]interface{}
+	if err := decoder.Decode(&converted); err != nil {
+		return nil, &TypeError{err: err.Error()}
+	}
+	for _, value := range converted {
+		format, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key, field := range format {
+			if !strings.HasSuffix(key, "-bin") {
+				continue
+			}
+			content, ok := field.(string)
+			if !ok {
+				return nil, &TypeError{err: key + " must be a string"}
+			}
+			decoded, err := base64.StdEncoding.DecodeString(content)
+			if err != nil {
+				return nil, &TypeError{err: "Invalid base64 in " + key +
+					": " + err.Error()}
+			}
+			delete(format, key)
+			format[strings.TrimSuffix(key, "-bin")] = decoded
+		}
+	}
+	return converted, nil
+}
+
+// Convert an item in the EPCP JSON shape with ConvertItem and encode it
+// as a tnetstring.
+func MarshalItem(item map[string]interface{}) ([]byte, error) {
+	converted, err := ConvertItem(item)
+	if err != nil {
+		return nil, err
+	}
+	return Marshal(converted)
+}
@@ -0,0 +1,45 @@
+//    item_test.go
+//    ~~~~~~~~~
+//    This module implements the EPCP item conversion tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package tnetstring
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConvertItem(t *testing.T) {
+	item, err := ConvertItem(map[string]interface{}{
+		"id":          "1",
+		"http-stream": map[string]interface{}{"content-bin": "AAE="},
+		"ws-message":  json.RawMessage(`{"content": "x", "code": 1000}`)})
+	assert.Nil(t, err)
+	assert.Equal(t, item, map[string]interface{}{
+		"id":          "1",
+		"http-stream": map[string]interface{}{"content": []byte{0, 1}},
+		"ws-message": map[string]interface{}{"content": "x",
+			"code": json.Number("1000")}})
+
+	encoded, err := MarshalItem(map[string]interface{}{"channel": "a",
+		"http-response": map[string]interface{}{"code": 200,
+			"body-bin": "/w=="}})
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded),
+		"59:7:channel,1:a,13:http-response,24:4:body,1:\xff,4:code,3:200#}}")
+}
+
+func TestConvertItemInvalid(t *testing.T) {
+	for _, item := range []map[string]interface{}{
+		{"http-stream": map[string]interface{}{"content-bin": "!"}},
+		{"http-stream": map[string]interface{}{"content-bin": 1}},
+		{"http-stream": make(chan int)}} {
+		_, err := MarshalItem(item)
+		_, ok := err.(*TypeError)
+		assert.True(t, ok, "%v", item)
+	}
+}
@@ -0,0 +1,291 @@
+//    tnetstring.go
+//    ~~~~~~~~~
+//    This module implements the tnetstring encoding and decoding.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+// Package tnetstring implements the tnetstring serialization format used
+// by Pushpin for the messages it receives over ZeroMQ. Unlike JSON,
+// tnetstrings carry binary strings as they are, without base64 encoding.
+package tnetstring
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// The maximum nesting depth of lists and dicts that is decoded.
+const maxDepth = 1000
+
+// Encode the specified value as a tnetstring. Strings and byte slices are
+// encoded as strings, integers of any size as integers, floats and
+// json.Number values that are not integers as floats, slices and arrays
+// as lists and maps with string keys as dicts, with their keys sorted so
+// that the output is deterministic. Nil is encoded as null. A TypeError
+// is returned for values of other types and for non-finite floats.
+func Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode the specified tnetstring, which must make up all of the data.
+// Strings are decoded as string values, integers as int64 values, floats
+// as float64 values, lists as []interface{} values and dicts as
+// map[string]interface{} values. A SyntaxError is returned if the data is
+// not a valid tnetstring.
+func Unmarshal(data []byte) (interface{}, error) {
+	value, rest, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, &SyntaxError{err: "Unexpected data after tnetstring"}
+	}
+	return value, nil
+}
+
+// Decode a single tnetstring from the start of the specified data and
+// return it along with the data that follows it. See Unmarshal for the
+// types of the decoded values.
+func Decode(data []byte) (interface{}, []byte, error) {
+	return decode(data, 0)
+}
+
+// An internal function that appends the tnetstring encoding of the
+// specified value to the buffer.
+func encode(buf *bytes.Buffer, value reflect.Value) error {
+	if !value.IsValid() {
+		buf.WriteString("0:~")
+		return nil
+	}
+	switch value := value.Interface().(type) {
+	case json.Number:
+		if _, err := strconv.ParseInt(value.String(), 10, 64); err == nil {
+			writeElement(buf, []byte(value.String()), '#')
+			return nil
+		}
+		f, err := value.Float64()
+		if err != nil {
+			return &TypeError{err: "Invalid number " +
+				strconv.Quote(value.String())}
+		}
+		return encodeFloat(buf, f)
+	}
+	switch value.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		return encode(buf, value.Elem())
+	case reflect.Bool:
+		writeElement(buf, []byte(strconv.FormatBool(value.Bool())), '!')
+	case reflect.String:
+		writeElement(buf, []byte(value.String()), ',')
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		writeElement(buf, []byte(strconv.FormatInt(value.Int(), 10)), '#')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		writeElement(buf, []byte(strconv.FormatUint(value.Uint(), 10)), '#')
+	case reflect.Float32, reflect.Float64:
+		return encodeFloat(buf, value.Float())
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice &&
+			value.Type().Elem().Kind() == reflect.Uint8 {
+			writeElement(buf, value.Bytes(), ',')
+			return nil
+		}
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			buf.WriteString("0:~")
+			return nil
+		}
+		var inner bytes.Buffer
+		for i := 0; i < value.Len(); i++ {
+			if err := encode(&inner, value.Index(i)); err != nil {
+				return err
+			}
+		}
+		writeElement(buf, inner.Bytes(), ']')
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String {
+			return &TypeError{err: "Cannot encode " + value.Type().String() +
+				" as a tnetstring: keys must be strings"}
+		}
+		if value.IsNil() {
+			buf.WriteString("0:~")
+			return nil
+		}
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		var inner bytes.Buffer
+		for _, key := range keys {
+			writeElement(&inner, []byte(key.String()), ',')
+			if err := encode(&inner, value.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		writeElement(buf, inner.Bytes(), '}')
+	default:
+		return &TypeError{err: "Cannot encode " + value.Type().String() +
+			" as a tnetstring"}
+	}
+	return nil
+}
+
+// An internal function that appends the tnetstring encoding of the
+// specified float to the buffer.
+func encodeFloat(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return &TypeError{err: "Cannot encode non-finite float " +
+			strconv.FormatFloat(f, 'g', -1, 64) + " as a tnetstring"}
+	}
+	writeElement(buf, []byte(strconv.FormatFloat(f, 'g', -1, 64)), '^')
+	return nil
+}
+
+// An internal function that appends an element with the specified payload
+// and type to the buffer.
+func writeElement(buf *bytes.Buffer, payload []byte, kind byte) {
+	buf.WriteString(strconv.Itoa(len(payload)))
+	buf.WriteByte(':')
+	buf.Write(payload)
+	buf.WriteByte(kind)
+}
+
+// An internal function that decodes a single tnetstring nested at the
+// specified depth.
+func decode(data []byte, depth int) (interface{}, []byte, error) {
+	colon := bytes.IndexByte(data, ':')
+	if colon <= 0 || colon > 9 {
+		return nil, nil, &SyntaxError{err: "Invalid tnetstring length"}
+	}
+	size := 0
+	for _, c := range data[:colon] {
+		if c < '0' || c > '9' {
+			return nil, nil, &SyntaxError{err: "Invalid tnetstring length"}
+		}
+		size = size*10 + int(c-'0')
+	}
+	if len(data)-colon-1 < size+1 {
+		return nil, nil, &SyntaxError{err: "Truncated tnetstring"}
+	}
+	payload := data[colon+1 : colon+1+size]
+	kind := data[colon+1+size]
+	rest := data[colon+2+size:]
+	switch kind {
+	case ',':
+		return string(payload), rest, nil
+	case '#':
+		value, err := strconv.ParseInt(string(payload), 10, 64)
+		if err != nil {
+			return nil, nil, &SyntaxError{err: "Invalid tnetstring integer " +
+				strconv.Quote(string(payload))}
+		}
+		return value, rest, nil
+	case '^':
+		value, err := strconv.ParseFloat(string(payload), 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, nil, &SyntaxError{err: "Invalid tnetstring float " +
+				strconv.Quote(string(payload))}
+		}
+		return value, rest, nil
+	case '!':
+		switch string(payload) {
+		case "true":
+			return true, rest, nil
+		case "false":
+			return false, rest, nil
+		}
+		return nil, nil, &SyntaxError{err: "Invalid tnetstring boolean " +
+			strconv.Quote(string(payload))}
+	case '~':
+		if size != 0 {
+			return nil, nil, &SyntaxError{err: "Invalid tnetstring null"}
+		}
+		return nil, rest, nil
+	case ']', '}':
+		if depth >= maxDepth {
+			return nil, nil, &SyntaxError{err: "Tnetstring nested too deeply"}
+		}
+		if kind == ']' {
+			list, err := decodeList(payload, depth+1)
+			return list, rest, err
+		}
+		dict, err := decodeDict(payload, depth+1)
+		return dict, rest, err
+	}
+	return nil, nil, &SyntaxError{err: "Invalid tnetstring type " +
+		strconv.QuoteRune(rune(kind))}
+}
+
+// An internal function that decodes the payload of a list.
+func decodeList(payload []byte, depth int) ([]interface{}, error) {
+	list := make([]interface{}, 0)
+	for len(payload) > 0 {
+		var element interface{}
+		var err error
+		element, payload, err = decode(payload, depth)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, element)
+	}
+	return list, nil
+}
+
+// An internal function that decodes the payload of a dict.
+func decodeDict(payload []byte, depth int) (map[string]interface{}, error) {
+	dict := make(map[string]interface{})
+	for len(payload) > 0 {
+		var key, value interface{}
+		var err error
+		key, payload, err = decode(payload, depth)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, &SyntaxError{err: "Tnetstring dict keys must be " +
+				"strings"}
+		}
+		if len(payload) == 0 {
+			return nil, &SyntaxError{err: "Missing tnetstring dict value"}
+		}
+		value, payload, err = decode(payload, depth)
+		if err != nil {
+			return nil, err
+		}
+		dict[name] = value
+	}
+	return dict, nil
+}
+
+// An error struct used to represent data that is not a valid tnetstring.
+type SyntaxError struct {
+	err string
+}
+
+// This function returns the message associated with the SyntaxError
+// error struct.
+func (e SyntaxError) Error() string {
+	return e.err
+}
+
+// An error struct used to represent a value that cannot be encoded as a
+// tnetstring.
+type TypeError struct {
+	err string
+}
+
+// This function returns the message associated with the TypeError error
+// struct.
+func (e TypeError) Error() string {
+	return e.err
+}
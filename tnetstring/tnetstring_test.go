@@ -0,0 +1,147 @@
+//    tnetstring_test.go
+//    ~~~~~~~~~
+//    This module implements the tnetstring tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package tnetstring
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	encoded, err := Marshal(map[string]interface{}{
+		"b": []interface{}{1, 2.5, true, nil},
+		"a": "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded),
+		"40:1:a,5:hello,1:b,20:1:1#3:2.5^4:true!0:~]}")
+	for value, expected := range map[interface{}]string{
+		int8(-5): "2:-5#", uint64(math.MaxUint64): "20:18446744073709551615#",
+		float32(0.5): "3:0.5^", json.Number("12"): "2:12#",
+		json.Number("1.5e3"): "4:1500^", false: "5:false!", "": "0:,"} {
+		encoded, err := Marshal(value)
+		assert.Nil(t, err)
+		assert.Equal(t, string(encoded), expected)
+	}
+	encoded, err = Marshal([]byte{0, 1})
+	assert.Nil(t, err)
+	assert.Equal(t, encoded, []byte("2:\x00\x01,"))
+	encoded, err = Marshal(json.RawMessage(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded), "2:{},")
+	encoded, err = Marshal(map[string][]string{"x": {"y"}, "z": nil})
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded), "18:1:x,4:1:y,]1:z,0:~}")
+	var pointer *int
+	encoded, err = Marshal(pointer)
+	assert.Nil(t, err)
+	assert.Equal(t, string(encoded), "0:~")
+}
+
+func TestMarshalInvalid(t *testing.T) {
+	for _, value := range []interface{}{struct{}{}, map[int]string{},
+		math.NaN(), math.Inf(1), json.Number("x"), make(chan int),
+		[]interface{}{func() {}}} {
+		_, err := Marshal(value)
+		_, ok := err.(*TypeError)
+		assert.True(t, ok, "%v", value)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	value := map[string]interface{}{
+		"channel": "test",
+		"http-stream": map[string]interface{}{
+			"content": "data\x00", "count": int64(-3), "ratio": 0.25},
+		"list": []interface{}{}, "ok": false, "none": nil}
+	encoded, err := Marshal(value)
+	assert.Nil(t, err)
+	decoded, err := Unmarshal(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, decoded, value)
+
+	decoded, rest, err := Decode([]byte("1:a,2:xy,"))
+	assert.Nil(t, err)
+	assert.Equal(t, decoded, "a")
+	assert.Equal(t, string(rest), "2:xy,")
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	for _, data := range []string{"", ":", "x:a,", "-1:a,", "+1:a,",
+		"5:abc,", "3:abc", "3:abc?", "1:x#", "3:NaN^", "1:x~", "4:TRUE!",
+		"4:1:a,}", "6:1:1#0:~}", "5:1:a,]", "1:a,1:b,", "0000000001:a,",
+		strings.Repeat("3:", maxDepth+1)} {
+		_, err := Unmarshal([]byte(data))
+		_, ok := err.(*SyntaxError)
+		assert.True(t, ok, data)
+	}
+	nested := "0:]"
+	for i := 0; i < maxDepth+1; i++ {
+		nested = strings.Join([]string{itoa(len(nested)), ":", nested, "]"},
+			"")
+	}
+	_, err := Unmarshal([]byte(nested))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "nested too deeply")
+}
+
+func itoa(n int) string {
+	encoded, _ := Marshal(n)
+	return string(encoded[bytes.IndexByte(encoded, ':')+1 : len(encoded)-1])
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range []string{"0:~", "5:hello,", "2:-5#", "3:0.5^",
+		"4:true!", "20:1:1#3:2.5^4:true!0:~]", "16:1:a,1:1#1:b,0:]}",
+		"3:1:a"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, err := Unmarshal(data)
+		if err != nil {
+			return
+		}
+		encoded, err := Marshal(value)
+		if err != nil {
+			t.Fatalf("Failed to marshal %#v: %s", value, err)
+		}
+		decoded, err := Unmarshal(encoded)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %q: %s", encoded, err)
+		}
+		reencoded, err := Marshal(decoded)
+		if err != nil || !bytes.Equal(encoded, reencoded) {
+			t.Fatalf("Round trip of %q is not stable: %q", encoded, reencoded)
+		}
+	})
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add("channel", "content", int64(1), 1.5, true)
+	f.Add("", "\x00\xff", int64(-1), -0.0, false)
+	f.Fuzz(func(t *testing.T, key, content string, n int64, x float64,
+		b bool) {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return
+		}
+		value := map[string]interface{}{key: []interface{}{content, n, x, b,
+			nil, map[string]interface{}{}}}
+		encoded, err := Marshal(value)
+		if err != nil {
+			t.Fatalf("Failed to marshal %#v: %s", value, err)
+		}
+		decoded, err := Unmarshal(encoded)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %q: %s", encoded, err)
+		}
+		assert.Equal(t, decoded, value)
+	})
+}
@@ -0,0 +1,96 @@
+//    tracing.go
+//    ~~~~~~~~~
+//    This module implements the OpenTelemetry tracing functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// The name of the instrumentation scope used for the tracers of this
+// package.
+const tracerName = "github.com/fanout/go-pubcontrol"
+
+// The attribute keys set on publish spans.
+const (
+	ChannelAttribute    = attribute.Key("pubcontrol.channel")
+	ItemCountAttribute  = attribute.Key("pubcontrol.item_count")
+	ClientAttribute     = attribute.Key("pubcontrol.client")
+	StatusCodeAttribute = attribute.Key("http.response.status_code")
+)
+
+// An internal type used as the context key for the tracer of a
+// PubControl instance.
+type tracerContextKey struct{}
+
+// An internal function that returns a context carrying the specified
+// tracer so that clients without a tracer of their own use it.
+func withTracer(ctx context.Context, tracer trace.Tracer) context.Context {
+	if tracer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tracerContextKey{}, tracer)
+}
+
+// An internal function that returns the tracer carried by the context or
+// nil if there is none.
+func tracerFromContext(ctx context.Context) trace.Tracer {
+	tracer, _ := ctx.Value(tracerContextKey{}).(trace.Tracer)
+	return tracer
+}
+
+// An internal function that returns the tracer of the specified provider
+// or nil if the provider is nil.
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return nil
+	}
+	return provider.Tracer(tracerName)
+}
+
+// An internal function that starts a span for publishing the specified
+// items if a tracer is available. The returned function must be called
+// with the result of the publish to end the span.
+func startPublishSpan(ctx context.Context, tracer trace.Tracer, name string,
+	kind trace.SpanKind, items []ChannelItem,
+	attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	if tracer == nil {
+		return ctx, func(err error) {}
+	}
+	attrs = append(attrs, ChannelAttribute.String(channelNames(items)),
+		ItemCountAttribute.Int(len(items)))
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// An internal function that records the HTTP status code of a publish
+// request on the current span, if any.
+func setSpanStatusCode(ctx context.Context, statusCode int) {
+	if statusCode != 0 {
+		trace.SpanFromContext(ctx).SetAttributes(
+			StatusCodeAttribute.Int(statusCode))
+	}
+}
+
+// An internal function that injects the W3C trace context of the current
+// span, if any, into the specified request headers.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(header))
+}
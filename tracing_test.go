@@ -0,0 +1,116 @@
+//    tracing_test.go
+//    ~~~~~~~~~
+//    This module implements the OpenTelemetry tracing tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestTracerProvider() (*sdktrace.TracerProvider,
+	*tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+		exporter
+}
+
+func spanAttribute(span tracetest.SpanStub,
+	key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestPcPublishTracing(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+		}))
+	defer server.Close()
+	provider, exporter := newTestTracerProvider()
+	pc := NewPubControl(nil)
+	pcc := NewPubControlClient(server.URL)
+	pcc.SetName("main")
+	pc.AddClient(pcc)
+	pc.SetTracerProvider(provider)
+	assert.Nil(t, pc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: newPolicyTestItem()},
+		{Channel: "b", Item: newPolicyTestItem()}}))
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 2)
+	child, root := spans[0], spans[1]
+	assert.Equal(t, root.Name, "pubcontrol.Publish")
+	assert.Equal(t, child.Name, "pubcontrol.PublishClient")
+	assert.Equal(t, child.Parent.SpanID(), root.SpanContext.SpanID())
+	assert.Equal(t, spanAttribute(root, ChannelAttribute).AsString(), "a, b")
+	assert.Equal(t, spanAttribute(root, ItemCountAttribute).AsInt64(),
+		int64(2))
+	assert.Equal(t, spanAttribute(child, ClientAttribute).AsString(), "main")
+	assert.Equal(t, spanAttribute(child, StatusCodeAttribute).AsInt64(),
+		int64(200))
+	assert.Equal(t, traceparent, "00-"+child.SpanContext.TraceID().String()+
+		"-"+child.SpanContext.SpanID().String()+"-01")
+}
+
+func TestPccPublishTracingFailure(t *testing.T) {
+	provider, exporter := newTestTracerProvider()
+	pcc := NewPubControlClient("uri")
+	pcc.SetTracerProvider(provider)
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		return 500, []byte("error"), nil
+	}
+	assert.NotNil(t, pcc.Publish("chan", newPolicyTestItem()))
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 1)
+	assert.Equal(t, spans[0].Status.Code, codes.Error)
+	assert.Equal(t, spanAttribute(spans[0], StatusCodeAttribute).AsInt64(),
+		int64(500))
+	assert.Equal(t, spanAttribute(spans[0], ChannelAttribute).AsString(),
+		"chan")
+}
+
+func TestPccPublishTracingPanic(t *testing.T) {
+	provider, exporter := newTestTracerProvider()
+	pcc := NewPubControlClient("uri")
+	pcc.SetTracerProvider(provider)
+	pcc.publish = func(pcc *PubControlClient, ctx context.Context,
+		items []ChannelItem) error {
+		panic("Intentional panic for tests")
+	}
+	assert.Panics(t, func() {
+		pcc.Publish("chan", newPolicyTestItem())
+	})
+	spans := exporter.GetSpans()
+	assert.Equal(t, len(spans), 1)
+	assert.Equal(t, spans[0].Status.Code, codes.Error)
+}
+
+func TestPccPublishNoTracing(t *testing.T) {
+	var header http.Header
+	pcc := NewPubControlClient("uri")
+	pcc.makeHttpRequest = func(pcc *PubControlClient, ctx context.Context,
+		uri, authHeader string, jsonContent []byte) (int, []byte, error) {
+		header = http.Header{}
+		injectTraceContext(ctx, header)
+		return 200, nil, nil
+	}
+	assert.Nil(t, pcc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, header.Get("traceparent"), "")
+}
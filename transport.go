@@ -0,0 +1,101 @@
+//    transport.go
+//    ~~~~~~~~~
+//    This module implements the Transport interface.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+)
+
+// The Transport interface delivers items that passed the interceptors,
+// circuit breaker and rate limiter of a PubControlClient. The default
+// transport of a client encodes the items as JSON and sends them to the
+// EPCP endpoint over HTTP. Set a different transport with the SetTransport
+// method, for example a ZmqTransport, to deliver items by other means.
+// Return an ItemFormatError for items that cannot be encoded so that the
+// circuit breaker does not count them as endpoint failures, and a
+// PartialPublishError if only some of the items were delivered.
+type Transport interface {
+	Send(ctx context.Context, items []ChannelItem) error
+}
+
+var (
+	_ Transport = httpTransport{}
+	_ Transport = (*ZmqTransport)(nil)
+	_ Transport = TransportFunc(nil)
+)
+
+// The TransportFunc type is an adapter that allows a function to be used
+// as a Transport.
+type TransportFunc func(ctx context.Context, items []ChannelItem) error
+
+// Call the function with the specified items.
+func (f TransportFunc) Send(ctx context.Context, items []ChannelItem) error {
+	return f(ctx, items)
+}
+
+// The httpTransport struct is the default transport of a PubControlClient,
+// which publishes to the EPCP endpoint of the client over HTTP using its
+// authentication settings.
+type httpTransport struct {
+	pcc *PubControlClient
+}
+
+// Export the specified items and publish them to the endpoint in a single
+// request.
+func (t httpTransport) Send(ctx context.Context, items []ChannelItem) error {
+	pcc := t.pcc
+	if encoded := encodedItemsFromContext(ctx, items); encoded != nil {
+		return t.sendEncoded(ctx, items, encoded)
+	}
+	exports := make([]map[string]interface{}, 0, len(items))
+	for _, entry := range items {
+		export, err := entry.Item.Export()
+		if err != nil {
+			return err
+		}
+		export["channel"] = entry.Channel
+		exports = append(exports, export)
+	}
+	pcc.lock.Lock()
+	uri := pcc.uri
+	auth, err := pcc.generateAuthHeader()
+	pcc.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	err = pcc.pubCall(pcc, ctx, uri, auth, exports)
+	if partial, ok := err.(*PartialPublishError); ok {
+		partial.delivered = items[:partial.count]
+	}
+	return err
+}
+
+// An internal method that publishes items that were already exported and
+// encoded by the client, so that they are not encoded a second time.
+func (t httpTransport) sendEncoded(ctx context.Context, items []ChannelItem,
+	encoded [][]byte) error {
+	pcc := t.pcc
+	pcc.lock.Lock()
+	uri := pcc.uri
+	maxRequestSize := pcc.maxRequestSize
+	auth, err := pcc.generateAuthHeader()
+	pcc.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	uri, err = publishUrl(uri, pcc.PublishPath())
+	if err != nil {
+		return err
+	}
+	err = pcc.sendRequests(ctx, uri, auth,
+		splitRequests(encoded, maxRequestSize), len(items))
+	if partial, ok := err.(*PartialPublishError); ok {
+		partial.delivered = items[:partial.count]
+	}
+	return err
+}
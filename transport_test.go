@@ -0,0 +1,79 @@
+//    transport_test.go
+//    ~~~~~~~~~
+//    This module implements the Transport tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDefaultTransport(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.SetAuthBearer("token")
+	var calls []map[string]interface{}
+	pcc.pubCall = func(pcc *PubControlClient, ctx context.Context, uri,
+		authHeader string, items []map[string]interface{}) error {
+		assert.Equal(t, uri, "uri")
+		assert.Equal(t, authHeader, "Bearer token")
+		calls = append(calls, items...)
+		return nil
+	}
+	_, ok := pcc.Transport().(httpTransport)
+	assert.True(t, ok)
+	assert.Nil(t, pcc.Publish("chan", NewItem([]Formatter{fmt1a}, "id", "")))
+	assert.Equal(t, calls, []map[string]interface{}{{"channel": "chan",
+		"id": "id", "test-format": "value1a"}})
+	err := pcc.Publish("chan", NewItem([]Formatter{fmt1a, fmt1b}, "", ""))
+	_, ok = err.(*ItemFormatError)
+	assert.True(t, ok)
+	assert.Equal(t, len(calls), 1)
+}
+
+func TestSetTransport(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	pcc.pubCall = func(pcc *PubControlClient, ctx context.Context, uri,
+		authHeader string, items []map[string]interface{}) error {
+		return errors.New("http")
+	}
+	var sent []ChannelItem
+	pcc.SetTransport(TransportFunc(func(ctx context.Context,
+		items []ChannelItem) error {
+		sent = append(sent, items...)
+		return nil
+	}))
+	item := NewItem([]Formatter{fmt1a}, "", "")
+	assert.Nil(t, pcc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: item}, {Channel: "b", Item: item}}))
+	assert.Equal(t, sent, []ChannelItem{{Channel: "a", Item: item},
+		{Channel: "b", Item: item}})
+
+	pcc.SetTransport(nil)
+	assert.Equal(t, pcc.Publish("a", item).Error(), "http")
+}
+
+func TestWrapTransport(t *testing.T) {
+	pcc := NewPubControlClient("uri")
+	published := 0
+	pcc.pubCall = func(pcc *PubControlClient, ctx context.Context, uri,
+		authHeader string, items []map[string]interface{}) error {
+		published += len(items)
+		return nil
+	}
+	next := pcc.Transport()
+	wrapped := 0
+	pcc.SetTransport(TransportFunc(func(ctx context.Context,
+		items []ChannelItem) error {
+		wrapped += len(items)
+		return next.Send(ctx, items)
+	}))
+	assert.Nil(t, pcc.Publish("a", NewItem([]Formatter{fmt1a}, "", "")))
+	assert.Equal(t, wrapped, 1)
+	assert.Equal(t, published, 1)
+}
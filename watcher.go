@@ -0,0 +1,354 @@
+//    watcher.go
+//    ~~~~~~~~~
+//    This module implements the ConfigWatcher functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The ConfigChanges struct describes the clients that were added, removed
+// or updated when a configuration was applied. Updated clients are those
+// whose configuration entry changed. They are updated in place, so that
+// settings made in code such as circuit breakers, rate limiters and
+// interceptors are kept.
+type ConfigChanges struct {
+	Added   []*PubControlClient
+	Removed []*PubControlClient
+	Updated []*PubControlClient
+}
+
+// Reports whether any clients were added, removed or updated.
+func (c ConfigChanges) Changed() bool {
+	return len(c.Added) > 0 || len(c.Removed) > 0 || len(c.Updated) > 0
+}
+
+// The ConfigWatcher struct keeps the clients of a PubControl instance in
+// sync with a JSON or YAML configuration file. The file is polled for
+// changes and each time it changes the clients created from the previous
+// version of the file are added, removed or updated so that they match
+// the new version. Clients that were added to the PubControl instance by
+// other means are left untouched. Publishes in progress during a reload
+// complete using the clients they started with.
+type ConfigWatcher struct {
+	pc       *PubControl
+	path     string
+	interval time.Duration
+	lock     sync.Mutex
+	owned    map[*PubControlClient]watchedClient
+	modTime  time.Time
+	size     int64
+	content  []byte
+	onReload func(changes ConfigChanges, err error)
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// An internal struct holding the configuration entry a watched client was
+// created from.
+type watchedClient struct {
+	key   string
+	entry map[string]interface{}
+}
+
+// Initialize this struct with the PubControl instance to update, the path
+// of the configuration file and the interval at which the file is polled.
+// An interval of zero defaults to one second.
+func NewConfigWatcher(pc *PubControl, path string,
+	interval time.Duration) *ConfigWatcher {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	w := new(ConfigWatcher)
+	w.pc = pc
+	w.path = path
+	w.interval = interval
+	w.owned = make(map[*PubControlClient]watchedClient)
+	return w
+}
+
+// Set a function that is called after every reload triggered by a change
+// of the file, with either the resulting changes or the error that
+// prevented the reload. The previous configuration stays in effect if an
+// error occurs.
+func (w *ConfigWatcher) SetOnReload(fn func(changes ConfigChanges,
+	err error)) {
+	w.lock.Lock()
+	w.onReload = fn
+	w.lock.Unlock()
+}
+
+// Load the configuration file and start polling it for changes. An error
+// is returned if the initial load fails.
+func (w *ConfigWatcher) Start() error {
+	if _, err := w.Reload(); err != nil {
+		return err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.stop != nil {
+		return nil
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.poll(w.stop, w.done)
+	return nil
+}
+
+// Stop polling the configuration file. The clients created from the file
+// remain configured.
+func (w *ConfigWatcher) Stop() {
+	w.lock.Lock()
+	stop, done := w.stop, w.done
+	w.stop, w.done = nil, nil
+	w.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Read the configuration file and apply it immediately, regardless of
+// whether it has changed.
+func (w *ConfigWatcher) Reload() (ConfigChanges, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return ConfigChanges{}, err
+	}
+	return w.reload(info)
+}
+
+// An internal method that polls the configuration file until the stop
+// channel is closed.
+func (w *ConfigWatcher) poll(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// An internal method that reloads the configuration file if its
+// modification time, size or content has changed.
+func (w *ConfigWatcher) check() {
+	w.lock.Lock()
+	info, err := os.Stat(w.path)
+	if err == nil && info.ModTime().Equal(w.modTime) &&
+		info.Size() == w.size {
+		w.lock.Unlock()
+		return
+	}
+	changes := ConfigChanges{}
+	if err == nil {
+		changes, err = w.reload(info)
+	}
+	onReload := w.onReload
+	w.lock.Unlock()
+	if logger := w.pc.getLogger(); err != nil && logger != nil {
+		logger.Error("Failed to reload publishing configuration",
+			slog.String("path", w.path), slog.String("error", err.Error()))
+	}
+	if onReload != nil && (err != nil || changes.Changed()) {
+		onReload(changes, err)
+	}
+}
+
+// An internal method that reads, parses and applies the configuration
+// file. The lock must be held by the caller.
+func (w *ConfigWatcher) reload(info os.FileInfo) (ConfigChanges, error) {
+	content, err := os.ReadFile(w.path)
+	if err != nil {
+		return ConfigChanges{}, err
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	if w.content != nil && bytes.Equal(content, w.content) {
+		return ConfigChanges{}, nil
+	}
+	config, err := ParseConfig(content, filepath.Ext(w.path))
+	if err != nil {
+		return ConfigChanges{}, err
+	}
+	w.content = content
+	changes := w.apply(config)
+	logConfigChanges(w.pc.getLogger(), w.path, changes)
+	return changes, nil
+}
+
+// An internal method that updates the clients of the PubControl instance
+// to match the specified configuration.
+func (w *ConfigWatcher) apply(
+	config []map[string]interface{}) ConfigChanges {
+	desired := make(map[string]map[string]interface{})
+	order := make([]string, 0, len(config))
+	seen := make(map[string]int)
+	for _, entry := range config {
+		if _, ok := entry["uri"].(string); !ok {
+			continue
+		}
+		key := configKey(entry)
+		if n := seen[key]; n > 0 {
+			seen[key]++
+			key = key + "#" + strconv.Itoa(n)
+		} else {
+			seen[key]++
+		}
+		desired[key] = entry
+		order = append(order, key)
+	}
+
+	pc := w.pc
+	pc.clientsRWLock.Lock()
+	defer pc.clientsRWLock.Unlock()
+	changes := ConfigChanges{}
+	owned := make(map[*PubControlClient]watchedClient)
+	placed := make(map[string]bool)
+	configured := make(map[*PubControlClient]map[string]interface{})
+	clients := make([]*PubControlClient, 0, len(pc.clients))
+	for _, pcc := range pc.clients {
+		watched, ok := w.owned[pcc]
+		if !ok {
+			clients = append(clients, pcc)
+			continue
+		}
+		entry, ok := desired[watched.key]
+		if !ok || placed[watched.key] {
+			changes.Removed = append(changes.Removed, pcc)
+			continue
+		}
+		placed[watched.key] = true
+		if !reflect.DeepEqual(entry, watched.entry) {
+			pcc.lock.Lock()
+			pcc.uri = entry["uri"].(string)
+			pcc.lock.Unlock()
+			configureClientAuth(pcc, entry)
+			configured[pcc] = entry
+			changes.Updated = append(changes.Updated, pcc)
+		}
+		owned[pcc] = watchedClient{key: watched.key, entry: entry}
+		clients = append(clients, pcc)
+	}
+	for _, key := range order {
+		if placed[key] {
+			continue
+		}
+		entry := desired[key]
+		pcc := clientFromConfig(entry)
+		configured[pcc] = entry
+		changes.Added = append(changes.Added, pcc)
+		owned[pcc] = watchedClient{key: key, entry: entry}
+		clients = append(clients, pcc)
+	}
+	pc.replaceClients(clients)
+	for pcc, entry := range configured {
+		pc.configureClient(pcc, entry)
+	}
+	pc.updateRing()
+	w.owned = owned
+	return changes
+}
+
+// An internal function that returns the key identifying the client of a
+// configuration entry across reloads, which is its name if it has one and
+// its normalized URI and authentication identity otherwise.
+func configKey(entry map[string]interface{}) string {
+	if name, ok := entry["name"].(string); ok && name != "" {
+		return "name:" + name
+	}
+	uri, _ := entry["uri"].(string)
+	return "uri:" + identityKey(uri, entryIdentity(entry))
+}
+
+// Read a JSON or YAML configuration file. See ParseConfig for the format.
+func LoadConfigFile(path string) ([]map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(content, filepath.Ext(path))
+}
+
+// Decode a key that is either specified as is or, so that binary JWT keys
+// can be specified, base64-encoded and prefixed with 'base64:'.
+func DecodeKey(key string) ([]byte, error) {
+	if !strings.HasPrefix(key, "base64:") {
+		return []byte(key), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key[7:])
+	if err != nil {
+		return nil, errors.New("Invalid base64 key: " + err.Error())
+	}
+	return decoded, nil
+}
+
+// Parse a configuration in the format accepted by the ApplyConfig method.
+// The content is parsed as YAML if the extension is '.yaml' or '.yml' and
+// as JSON otherwise. The content can either be a single configuration
+// entry or a list of entries. Keys prefixed with 'base64:' are decoded
+// using DecodeKey.
+func ParseConfig(content []byte,
+	extension string) ([]map[string]interface{}, error) {
+	var parsed interface{}
+	var err error
+	switch strings.ToLower(extension) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &parsed)
+	default:
+		err = json.Unmarshal(content, &parsed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []interface{}
+	switch parsed := parsed.(type) {
+	case []interface{}:
+		entries = parsed
+	case map[string]interface{}:
+		entries = []interface{}{parsed}
+	case nil:
+	default:
+		return nil, errors.New("Configuration must be an entry or a list " +
+			"of entries")
+	}
+	config := make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		entry, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Configuration entries must be objects")
+		}
+		if key, ok := entry["key"].(string); ok &&
+			strings.HasPrefix(key, "base64:") {
+			decoded, err := DecodeKey(key)
+			if err != nil {
+				return nil, err
+			}
+			entry["key"] = decoded
+		}
+		config = append(config, entry)
+	}
+	return config, nil
+}
@@ -0,0 +1,207 @@
+//    watcher_test.go
+//    ~~~~~~~~~
+//    This module implements the ConfigWatcher tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigJson(t *testing.T) {
+	config, err := ParseConfig([]byte(`[{"uri": "uri", "iss": "iss", `+
+		`"key": "base64:a2V5"}, {"uri": "uri2", "weight": 2}]`), ".json")
+	assert.Nil(t, err)
+	assert.Equal(t, len(config), 2)
+	assert.Equal(t, config[0]["key"], []byte("key"))
+	assert.Equal(t, config[1]["weight"], float64(2))
+	config, err = ParseConfig([]byte(`{"uri": "uri"}`), "")
+	assert.Nil(t, err)
+	assert.Equal(t, config[0]["uri"], "uri")
+	_, err = ParseConfig([]byte(`"uri"`), ".json")
+	assert.NotNil(t, err)
+	_, err = ParseConfig([]byte(`["uri"]`), ".json")
+	assert.NotNil(t, err)
+	_, err = ParseConfig([]byte(`{"uri": "uri", "key": "base64:!"}`), "")
+	assert.NotNil(t, err)
+}
+
+func TestDecodeKey(t *testing.T) {
+	key, err := DecodeKey("key")
+	assert.Nil(t, err)
+	assert.Equal(t, key, []byte("key"))
+	key, err = DecodeKey("base64:a2V5")
+	assert.Nil(t, err)
+	assert.Equal(t, key, []byte("key"))
+	key, err = DecodeKey("base64:!")
+	assert.Nil(t, key)
+	assert.True(t, strings.HasPrefix(err.Error(), "Invalid base64 key: "))
+}
+
+func TestParseConfigYaml(t *testing.T) {
+	config, err := ParseConfig([]byte("- uri: uri\n  include:\n"+
+		"    - a.*\n- uri: uri2\n  key: token\n"), ".YML")
+	assert.Nil(t, err)
+	assert.Equal(t, len(config), 2)
+	assert.Equal(t, config[0]["include"], []interface{}{"a.*"})
+	assert.Equal(t, config[1]["key"], "token")
+	config, err = ParseConfig([]byte(""), ".yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, len(config), 0)
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0600))
+	// Make sure the modification time changes even on coarse filesystems.
+	mod := time.Now().Add(time.Duration(len(content)) * time.Second)
+	assert.Nil(t, os.Chtimes(path, mod, mod))
+}
+
+func TestConfigWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, `[{"uri": "uri1"}, {"uri": "uri2", `+
+		`"key": "old"}]`)
+	pc := NewPubControl(nil)
+	manual := NewPubControlClient("manual")
+	pc.AddClient(manual)
+	w := NewConfigWatcher(pc, path, 0)
+	changes, err := w.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, len(changes.Added), 2)
+	clients := pc.Clients()
+	assert.Equal(t, len(clients), 3)
+	assert.Equal(t, clients[0], manual)
+	uri1 := clients[1]
+	assert.Equal(t, uri1.uri, "uri1")
+
+	writeConfigFile(t, path, `[{"uri": "uri1"}, {"uri": "uri2", `+
+		`"key": "token"}, {"uri": "uri3", "include": "a.*"}]`)
+	changes, err = w.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, len(changes.Added), 1)
+	assert.Equal(t, len(changes.Updated), 1)
+	assert.Equal(t, len(changes.Removed), 0)
+	assert.Equal(t, changes.Updated[0].authBearerKey, "token")
+	clients = pc.Clients()
+	assert.Equal(t, len(clients), 4)
+	assert.Equal(t, clients[1], uri1)
+	assert.Equal(t, clients[2], changes.Updated[0])
+	assert.NotNil(t, pc.routes[changes.Added[0]])
+
+	writeConfigFile(t, path, `[{"uri": "uri3", "include": "a.*"}]`)
+	changes, err = w.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, len(changes.Removed), 2)
+	assert.Equal(t, pc.Clients(), []*PubControlClient{manual, clients[3]})
+	assert.NotNil(t, pc.routes[clients[3]])
+
+	changes, err = w.Reload()
+	assert.Nil(t, err)
+	assert.False(t, changes.Changed())
+}
+
+func TestConfigWatcherNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "- uri: uri1\n  name: main\n- uri: uri1\n")
+	pc := NewPubControl(nil)
+	w := NewConfigWatcher(pc, path, 0)
+	_, err := w.Reload()
+	assert.Nil(t, err)
+	main := pc.Client("main")
+	assert.NotNil(t, main)
+	writeConfigFile(t, path, "- uri: uri1\n- uri: uri2\n  name: main\n")
+	changes, err := w.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, len(changes.Updated), 1)
+	assert.Equal(t, changes.Updated[0].uri, "uri2")
+	assert.Equal(t, len(pc.Clients()), 2)
+}
+
+func TestConfigWatcherUpdateKeepsSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, `[{"uri": "uri1", "name": "main"}]`)
+	pc := NewPubControl(nil)
+	w := NewConfigWatcher(pc, path, 0)
+	_, err := w.Reload()
+	assert.Nil(t, err)
+	main := pc.Client("main")
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{})
+	main.SetCircuitBreaker(breaker)
+	main.pubCall = pubCallTestMethod
+	intercepted := 0
+	main.AddInterceptor(func(ctx context.Context, channel string,
+		item *Item, next PublishFunc) error {
+		intercepted++
+		return next(ctx, channel, item)
+	})
+
+	writeConfigFile(t, path, `[{"uri": "uri2", "name": "main", `+
+		`"key": "token"}]`)
+	changes, err := w.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, changes.Updated, []*PubControlClient{main})
+	assert.Equal(t, pc.Client("main"), main)
+	assert.Equal(t, main.Uri(), "uri2")
+	assert.Equal(t, main.authBearerKey, "token")
+	assert.Equal(t, main.CircuitBreaker(), breaker)
+	assert.Nil(t, pc.Publish("chan", newPolicyTestItem()))
+	assert.Equal(t, intercepted, 1)
+}
+
+func TestConfigWatcherPolling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfigFile(t, path, `[{"uri": "uri1"}]`)
+	pc := NewPubControl(nil)
+	w := NewConfigWatcher(pc, path, 5*time.Millisecond)
+	reloads := make(chan ConfigChanges, 10)
+	errs := make(chan error, 10)
+	w.SetOnReload(func(changes ConfigChanges, err error) {
+		if err != nil {
+			errs <- err
+		} else {
+			reloads <- changes
+		}
+	})
+	assert.Nil(t, w.Start())
+	assert.Nil(t, w.Start())
+	defer w.Stop()
+	assert.Equal(t, len(pc.Clients()), 1)
+
+	writeConfigFile(t, path, `[{"uri": "uri1"}, {"uri": "uri2"}]`)
+	select {
+	case changes := <-reloads:
+		assert.Equal(t, len(changes.Added), 1)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+	assert.Equal(t, len(pc.Clients()), 2)
+
+	writeConfigFile(t, path, `[{"uri": `)
+	select {
+	case err := <-errs:
+		assert.NotNil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration error was not reported")
+	}
+	assert.Equal(t, len(pc.Clients()), 2)
+	w.Stop()
+	w.Stop()
+}
+
+func TestConfigWatcherStartError(t *testing.T) {
+	w := NewConfigWatcher(NewPubControl(nil),
+		filepath.Join(t.TempDir(), "missing.json"), 0)
+	assert.NotNil(t, w.Start())
+	content, err := LoadConfigFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Nil(t, content)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,350 @@
+//    zmq.go
+//    ~~~~~~~~~
+//    This module implements the ZmqTransport functionality.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"github.com/fanout/go-pubcontrol/tnetstring"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The type of ZeroMQ socket that a ZmqTransport publishes through.
+type ZmqSocketType int
+
+const (
+	// Send every item to the connected PULL socket, for example the
+	// 'push_in_spec' socket of Pushpin.
+	ZmqPush ZmqSocketType = iota
+
+	// Send every item to the connected SUB socket if it is subscribed to
+	// the channel of the item, for example the 'push_in_sub_spec' socket of
+	// Pushpin.
+	ZmqPub
+)
+
+// The time allowed for connecting and completing the ZMTP handshake when
+// the context of the publish has no deadline.
+const zmqConnectTimeout = 10 * time.Second
+
+// Get the ZMTP name of the socket type.
+func (s ZmqSocketType) String() string {
+	if s == ZmqPub {
+		return "PUB"
+	}
+	return "PUSH"
+}
+
+// An internal method that reports whether a peer of the specified socket
+// type can receive messages from this socket type.
+func (s ZmqSocketType) accepts(peerType string) bool {
+	if s == ZmqPub {
+		return peerType == "SUB" || peerType == "XSUB"
+	}
+	return peerType == "PULL"
+}
+
+// The ZmqTransport struct publishes items to a ZeroMQ socket, such as the
+// PULL or SUB socket that Pushpin binds for receiving publishes, using a
+// pure Go implementation of the ZMTP 3.0 protocol. Items are encoded as
+// tnetstrings. With a PUSH socket each item is sent as a single frame that
+// includes the channel; with a PUB socket each item is sent as a channel
+// frame followed by the item, and only if the peer is subscribed to the
+// channel. The connection is established on the first publish and
+// re-established after it fails. Set a ZmqTransport on a PubControlClient
+// with the SetTransport method to publish through it instead of HTTP.
+type ZmqTransport struct {
+	uri        string
+	network    string
+	address    string
+	socketType ZmqSocketType
+	lock       sync.Mutex
+	conn       *zmqConn
+	closed     bool
+}
+
+// An internal struct representing an established ZMTP connection along
+// with the subscriptions received over it.
+type zmqConn struct {
+	conn          net.Conn
+	writeLock     sync.Mutex
+	lock          sync.Mutex
+	subscriptions map[string]int
+	done          chan struct{}
+}
+
+// Initialize this struct with the URI of the socket to connect to and the
+// type of socket to publish as. The URI is either 'tcp://host:port' or
+// 'ipc:///path/to/socket'.
+func NewZmqTransport(uri string,
+	socketType ZmqSocketType) (*ZmqTransport, error) {
+	t := new(ZmqTransport)
+	t.uri = uri
+	t.socketType = socketType
+	if strings.HasPrefix(uri, "tcp://") {
+		t.network = "tcp"
+		t.address = strings.TrimPrefix(uri, "tcp://")
+		if _, _, err := net.SplitHostPort(t.address); err != nil {
+			return nil, &ZmqError{err: "Invalid ZeroMQ URI " + uri + ": " +
+				err.Error()}
+		}
+	} else if strings.HasPrefix(uri, "ipc://") {
+		t.network = "unix"
+		t.address = strings.TrimPrefix(uri, "ipc://")
+		if t.address == "" {
+			return nil, &ZmqError{err: "Invalid ZeroMQ URI " + uri +
+				": missing path"}
+		}
+	} else {
+		return nil, &ZmqError{err: "Invalid ZeroMQ URI " + uri +
+			": the scheme must be tcp or ipc"}
+	}
+	return t, nil
+}
+
+// Get the URI of the socket this transport connects to.
+func (t *ZmqTransport) Uri() string {
+	return t.uri
+}
+
+// Publish the specified items to the socket. An ItemFormatError is
+// returned if an item cannot be exported, a ZmqError if the connection
+// cannot be established and a PartialPublishError if the connection
+// failed after some of the items were written.
+func (t *ZmqTransport) Send(ctx context.Context, items []ChannelItem) error {
+	messages := make([][][]byte, 0, len(items))
+	for _, entry := range items {
+		export, err := entry.Item.Export()
+		if err != nil {
+			return err
+		}
+		message, err := t.encode(entry.Channel, export)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, message)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return &ZmqError{err: "The ZeroMQ transport is closed"}
+	}
+	conn, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	sent, err := conn.send(ctx, t.socketType, items, messages)
+	if err != nil {
+		conn.close()
+		t.conn = nil
+	}
+	if err != nil && sent > 0 {
+		partial := newPartialPublishError(sent, len(items), err)
+		partial.delivered = items[:sent]
+		return partial
+	}
+	return err
+}
+
+// Close the connection to the socket. Publishing through a closed
+// transport fails.
+func (t *ZmqTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = true
+	if t.conn != nil {
+		t.conn.close()
+		t.conn = nil
+	}
+	return nil
+}
+
+// An internal method that encodes an exported item into the frames of a
+// message for the socket type of this transport.
+func (t *ZmqTransport) encode(channel string,
+	export map[string]interface{}) ([][]byte, error) {
+	item, err := tnetstring.ConvertItem(export)
+	if err != nil {
+		return nil, &ItemFormatError{err: err.Error()}
+	}
+	if t.socketType == ZmqPush {
+		item["channel"] = channel
+	}
+	encoded, err := tnetstring.Marshal(item)
+	if err != nil {
+		return nil, &ItemFormatError{err: err.Error()}
+	}
+	if t.socketType == ZmqPub {
+		return [][]byte{[]byte(channel), encoded}, nil
+	}
+	return [][]byte{encoded}, nil
+}
+
+// An internal method that returns the current connection, establishing a
+// new one if there is none or the previous one failed. The lock must be
+// held by the caller.
+func (t *ZmqTransport) connect(ctx context.Context) (*zmqConn, error) {
+	if t.conn != nil {
+		select {
+		case <-t.conn.done:
+			t.conn.close()
+			t.conn = nil
+		default:
+			return t.conn, nil
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, zmqConnectTimeout)
+		defer cancel()
+	}
+	dialer := net.Dialer{}
+	netConn, err := dialer.DialContext(ctx, t.network, t.address)
+	if err != nil {
+		return nil, &ZmqError{err: "Failed to connect to " + t.uri + ": " +
+			err.Error()}
+	}
+	deadline, _ := ctx.Deadline()
+	netConn.SetDeadline(deadline)
+	peerType, err := zmtpHandshake(netConn, t.socketType.String())
+	if err == nil && !t.socketType.accepts(peerType) {
+		err = &ZmqError{err: "Incompatible peer socket type " + peerType}
+	}
+	if err != nil {
+		netConn.Close()
+		if _, ok := err.(*ZmqError); ok {
+			return nil, err
+		}
+		return nil, &ZmqError{err: "ZMTP handshake with " + t.uri +
+			" failed: " + err.Error()}
+	}
+	netConn.SetDeadline(time.Time{})
+	conn := &zmqConn{conn: netConn, subscriptions: make(map[string]int),
+		done: make(chan struct{})}
+	go conn.read()
+	t.conn = conn
+	return conn, nil
+}
+
+// An internal method that writes the messages of the specified items,
+// skipping items nobody is subscribed to in the case of a PUB socket. The
+// number of items that were written or skipped before an error occurred
+// is returned.
+func (c *zmqConn) send(ctx context.Context, socketType ZmqSocketType,
+	items []ChannelItem, messages [][][]byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetWriteDeadline(time.Now())
+	})
+	defer stop()
+	for i, message := range messages {
+		if socketType == ZmqPub && !c.subscribed(items[i].Channel) {
+			continue
+		}
+		if err := writeZmtpMessage(c.conn, message...); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return i, ctxErr
+			}
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
+// An internal method that reads from the connection until it fails,
+// keeping track of subscriptions and answering heartbeats.
+func (c *zmqConn) read() {
+	defer close(c.done)
+	for {
+		frame, err := readZmtpFrame(c.conn)
+		if err != nil {
+			return
+		}
+		if !frame.command {
+			// ZMTP 3.0 subscriptions are messages starting with 1 to
+			// subscribe and 0 to unsubscribe, followed by the topic.
+			if len(frame.body) > 0 && !frame.more {
+				c.subscribe(string(frame.body[1:]), frame.body[0] == 1)
+			}
+			continue
+		}
+		name, data, err := parseZmtpCommand(frame)
+		if err != nil {
+			return
+		}
+		switch name {
+		case "SUBSCRIBE":
+			c.subscribe(string(data), true)
+		case "CANCEL":
+			c.subscribe(string(data), false)
+		case "PING":
+			if len(data) >= 2 {
+				c.writeLock.Lock()
+				err = writeZmtpCommand(c.conn, "PONG", data[2:])
+				c.writeLock.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		case "ERROR":
+			return
+		}
+	}
+}
+
+// An internal method that adds or removes a subscription to the specified
+// topic prefix.
+func (c *zmqConn) subscribe(topic string, add bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if add {
+		c.subscriptions[topic]++
+	} else if c.subscriptions[topic] > 1 {
+		c.subscriptions[topic]--
+	} else {
+		delete(c.subscriptions, topic)
+	}
+}
+
+// An internal method that reports whether the peer is subscribed to a
+// prefix of the specified channel.
+func (c *zmqConn) subscribed(channel string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for topic := range c.subscriptions {
+		if strings.HasPrefix(channel, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// An internal method that closes the connection.
+func (c *zmqConn) close() {
+	c.conn.Close()
+}
+
+// An error struct used to represent an error related to a ZeroMQ
+// connection.
+type ZmqError struct {
+	err string
+}
+
+// This function returns the message associated with the ZmqError error
+// struct.
+func (e ZmqError) Error() string {
+	return e.err
+}
@@ -0,0 +1,208 @@
+//    zmq_test.go
+//    ~~~~~~~~~
+//    This module implements the ZmqTransport tests.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fanout/go-pubcontrol/tnetstring"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A test peer that accepts connections and performs the ZMTP handshake as
+// the specified socket type.
+type zmtpTestPeer struct {
+	listener net.Listener
+	conns    chan net.Conn
+}
+
+func newZmtpTestPeer(t *testing.T, network, address,
+	socketType string) *zmtpTestPeer {
+	listener, err := net.Listen(network, address)
+	assert.Nil(t, err)
+	peer := &zmtpTestPeer{listener: listener, conns: make(chan net.Conn, 4)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if _, err := zmtpHandshake(conn, socketType); err != nil {
+				conn.Close()
+				continue
+			}
+			peer.conns <- conn
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return peer
+}
+
+func (p *zmtpTestPeer) accept(t *testing.T) net.Conn {
+	select {
+	case conn := <-p.conns:
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("No connection accepted")
+		return nil
+	}
+}
+
+func readTestMessage(t *testing.T, conn net.Conn) []zmtpFrame {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	frames := make([]zmtpFrame, 0)
+	for {
+		frame, err := readZmtpFrame(conn)
+		if !assert.Nil(t, err) {
+			return frames
+		}
+		frames = append(frames, frame)
+		if !frame.more {
+			return frames
+		}
+	}
+}
+
+func decodeTestItem(t *testing.T, data []byte) map[string]interface{} {
+	value, err := tnetstring.Unmarshal(data)
+	assert.Nil(t, err)
+	item, _ := value.(map[string]interface{})
+	return item
+}
+
+func zmqTestItem(content string) *Item {
+	return NewItem([]Formatter{rawFormatter{name: "http-stream",
+		raw: json.RawMessage(content)}}, "", "")
+}
+
+func TestZmqTransportPush(t *testing.T) {
+	peer := newZmtpTestPeer(t, "tcp", "127.0.0.1:0", "PULL")
+	transport, err := NewZmqTransport("tcp://"+peer.listener.Addr().String(),
+		ZmqPush)
+	assert.Nil(t, err)
+	defer transport.Close()
+	pcc := NewPubControlClient("http://localhost:1")
+	pcc.SetTransport(transport)
+	err = pcc.PublishBatch(context.Background(), []ChannelItem{
+		{Channel: "a", Item: zmqTestItem(`{"content": "hello"}`)},
+		{Channel: "b", Item: zmqTestItem(`{"content-bin": "AAE="}`)}})
+	assert.Nil(t, err)
+	conn := peer.accept(t)
+	frames := readTestMessage(t, conn)
+	assert.Equal(t, len(frames), 1)
+	assert.Equal(t, decodeTestItem(t, frames[0].body), map[string]interface{}{
+		"channel": "a", "http-stream": map[string]interface{}{
+			"content": "hello"}})
+	frames = readTestMessage(t, conn)
+	assert.Equal(t, decodeTestItem(t, frames[0].body), map[string]interface{}{
+		"channel": "b", "http-stream": map[string]interface{}{
+			"content": "\x00\x01"}})
+
+	assert.Nil(t, writeZmtpCommand(conn, "PING", []byte("\x00\x00ctx")))
+	frames = readTestMessage(t, conn)
+	name, data, err := parseZmtpCommand(frames[0])
+	assert.Nil(t, err)
+	assert.Equal(t, name, "PONG")
+	assert.Equal(t, string(data), "ctx")
+}
+
+func TestZmqTransportPub(t *testing.T) {
+	peer := newZmtpTestPeer(t, "unix",
+		filepath.Join(t.TempDir(), "pub.sock"), "SUB")
+	transport, err := NewZmqTransport("ipc://"+peer.listener.Addr().String(),
+		ZmqPub)
+	assert.Nil(t, err)
+	defer transport.Close()
+	transport.lock.Lock()
+	conn, err := transport.connect(context.Background())
+	transport.lock.Unlock()
+	assert.Nil(t, err)
+	peerConn := peer.accept(t)
+	assert.Nil(t, writeZmtpMessage(peerConn, []byte("\x01a.")))
+	assert.Eventually(t, func() bool {
+		return conn.subscribed("a.1")
+	}, 5*time.Second, time.Millisecond)
+	assert.False(t, conn.subscribed("b.1"))
+
+	err = transport.Send(context.Background(), []ChannelItem{
+		{Channel: "b.1", Item: zmqTestItem(`{"content": "skipped"}`)},
+		{Channel: "a.1", Item: zmqTestItem(`{"content": "sent"}`)}})
+	assert.Nil(t, err)
+	frames := readTestMessage(t, peerConn)
+	assert.Equal(t, len(frames), 2)
+	assert.Equal(t, string(frames[0].body), "a.1")
+	assert.Equal(t, decodeTestItem(t, frames[1].body), map[string]interface{}{
+		"http-stream": map[string]interface{}{"content": "sent"}})
+
+	assert.Nil(t, writeZmtpMessage(peerConn, []byte("\x00a.")))
+	assert.Eventually(t, func() bool {
+		return !conn.subscribed("a.1")
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestZmqTransportReconnect(t *testing.T) {
+	peer := newZmtpTestPeer(t, "tcp", "127.0.0.1:0", "PULL")
+	transport, err := NewZmqTransport("tcp://"+peer.listener.Addr().String(),
+		ZmqPush)
+	assert.Nil(t, err)
+	defer transport.Close()
+	items := []ChannelItem{{Channel: "a", Item: zmqTestItem(`{"content": "x"}`)}}
+	assert.Nil(t, transport.Send(context.Background(), items))
+	first := peer.accept(t)
+	readTestMessage(t, first)
+	transport.lock.Lock()
+	conn := transport.conn
+	transport.lock.Unlock()
+	first.Close()
+	select {
+	case <-conn.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Closed connection not detected")
+	}
+	assert.Nil(t, transport.Send(context.Background(), items))
+	frames := readTestMessage(t, peer.accept(t))
+	assert.Equal(t, decodeTestItem(t, frames[0].body)["channel"], "a")
+
+	transport.Close()
+	err = transport.Send(context.Background(), items)
+	_, ok := err.(*ZmqError)
+	assert.True(t, ok)
+}
+
+func TestZmqTransportErrors(t *testing.T) {
+	for _, uri := range []string{"http://localhost:5560", "tcp://localhost",
+		"ipc://"} {
+		_, err := NewZmqTransport(uri, ZmqPush)
+		_, ok := err.(*ZmqError)
+		assert.True(t, ok, uri)
+	}
+	peer := newZmtpTestPeer(t, "tcp", "127.0.0.1:0", "PUB")
+	transport, err := NewZmqTransport("tcp://"+peer.listener.Addr().String(),
+		ZmqPush)
+	assert.Nil(t, err)
+	err = transport.Send(context.Background(), []ChannelItem{
+		{Channel: "a", Item: zmqTestItem(`{"content": "x"}`)}})
+	_, ok := err.(*ZmqError)
+	assert.True(t, ok)
+	assert.Contains(t, err.Error(), "Incompatible peer socket type PUB")
+	err = transport.Send(context.Background(), []ChannelItem{
+		{Channel: "a", Item: zmqTestItem(`{"content-bin": "!"}`)}})
+	_, ok = err.(*ItemFormatError)
+	assert.True(t, ok)
+	assert.Equal(t, ZmqPub.String(), "PUB")
+	assert.Equal(t, ZmqPush.String(), "PUSH")
+}
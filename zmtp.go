@@ -0,0 +1,210 @@
+//    zmtp.go
+//    ~~~~~~~~~
+//    This module implements the ZMTP 3.0 wire protocol used by the ZeroMQ
+//    transport.
+//    :authors: Konstantin Bokarius.
+//    :copyright: (c) 2015 by Fanout, Inc.
+//    :license: MIT, see LICENSE for more details.
+
+package pubcontrol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The ZMTP constants used by this implementation.
+const (
+	zmtpGreetingSize = 64
+	zmtpFlagMore     = 0x01
+	zmtpFlagLong     = 0x02
+	zmtpFlagCommand  = 0x04
+	zmtpMaxFrameSize = 1 << 20
+)
+
+// The zmtpFrame struct represents a single frame read from a peer.
+type zmtpFrame struct {
+	more    bool
+	command bool
+	body    []byte
+}
+
+// An internal function that returns the greeting announcing ZMTP 3.0
+// with the NULL security mechanism.
+func zmtpGreeting() []byte {
+	greeting := make([]byte, zmtpGreetingSize)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3
+	copy(greeting[12:32], "NULL")
+	return greeting
+}
+
+// An internal function that performs the ZMTP handshake as the specified
+// socket type and returns the socket type of the peer.
+func zmtpHandshake(rw io.ReadWriter, socketType string) (string, error) {
+	if _, err := rw.Write(zmtpGreeting()); err != nil {
+		return "", err
+	}
+	greeting := make([]byte, zmtpGreetingSize)
+	if _, err := io.ReadFull(rw, greeting); err != nil {
+		return "", err
+	}
+	if greeting[0] != 0xff || greeting[9] != 0x7f {
+		return "", errors.New("Invalid ZMTP greeting")
+	}
+	if greeting[10] < 3 {
+		return "", fmt.Errorf("Unsupported ZMTP version %d.%d", greeting[10],
+			greeting[11])
+	}
+	if mechanism := string(bytes.TrimRight(greeting[12:32], "\x00")); mechanism != "NULL" {
+		return "", fmt.Errorf("Unsupported ZMTP mechanism %q", mechanism)
+	}
+	var properties bytes.Buffer
+	writeZmtpProperty(&properties, "Socket-Type", socketType)
+	if err := writeZmtpCommand(rw, "READY", properties.Bytes()); err != nil {
+		return "", err
+	}
+	frame, err := readZmtpFrame(rw)
+	if err != nil {
+		return "", err
+	}
+	name, data, err := parseZmtpCommand(frame)
+	if err != nil {
+		return "", err
+	}
+	if name == "ERROR" {
+		return "", fmt.Errorf("ZMTP peer error: %s", zmtpErrorReason(data))
+	}
+	if name != "READY" {
+		return "", fmt.Errorf("Unexpected ZMTP command %q", name)
+	}
+	peerProperties, err := parseZmtpProperties(data)
+	if err != nil {
+		return "", err
+	}
+	return peerProperties["Socket-Type"], nil
+}
+
+// An internal function that writes a ZMTP property to the buffer.
+func writeZmtpProperty(buf *bytes.Buffer, name, value string) {
+	buf.WriteByte(byte(len(name)))
+	buf.WriteString(name)
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.WriteString(value)
+}
+
+// An internal function that appends a frame with the specified flags and
+// body to the buffer, using a long size if required.
+func appendZmtpFrame(buf *bytes.Buffer, flags byte, body []byte) {
+	if len(body) > 255 {
+		buf.WriteByte(flags | zmtpFlagLong)
+		binary.Write(buf, binary.BigEndian, uint64(len(body)))
+	} else {
+		buf.WriteByte(flags)
+		buf.WriteByte(byte(len(body)))
+	}
+	buf.Write(body)
+}
+
+// An internal function that writes a command with the specified name and
+// data.
+func writeZmtpCommand(w io.Writer, name string, data []byte) error {
+	body := make([]byte, 0, 1+len(name)+len(data))
+	body = append(body, byte(len(name)))
+	body = append(body, name...)
+	body = append(body, data...)
+	var buf bytes.Buffer
+	appendZmtpFrame(&buf, zmtpFlagCommand, body)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// An internal function that writes a message consisting of the specified
+// parts with a single write.
+func writeZmtpMessage(w io.Writer, parts ...[]byte) error {
+	var buf bytes.Buffer
+	for i, part := range parts {
+		var flags byte
+		if i < len(parts)-1 {
+			flags = zmtpFlagMore
+		}
+		appendZmtpFrame(&buf, flags, part)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// An internal function that reads a single frame.
+func readZmtpFrame(r io.Reader) (zmtpFrame, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return zmtpFrame{}, err
+	}
+	flags := header[0]
+	if flags&^(zmtpFlagMore|zmtpFlagLong|zmtpFlagCommand) != 0 {
+		return zmtpFrame{}, fmt.Errorf("Invalid ZMTP frame flags %#x", flags)
+	}
+	size := uint64(header[1])
+	if flags&zmtpFlagLong != 0 {
+		if _, err := io.ReadFull(r, header[2:9]); err != nil {
+			return zmtpFrame{}, err
+		}
+		size = binary.BigEndian.Uint64(header[1:9])
+	}
+	if size > zmtpMaxFrameSize {
+		return zmtpFrame{}, fmt.Errorf("ZMTP frame of %d bytes is too large",
+			size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return zmtpFrame{}, err
+	}
+	return zmtpFrame{more: flags&zmtpFlagMore != 0,
+		command: flags&zmtpFlagCommand != 0, body: body}, nil
+}
+
+// An internal function that splits a command frame into its name and
+// data.
+func parseZmtpCommand(frame zmtpFrame) (string, []byte, error) {
+	if !frame.command {
+		return "", nil, errors.New("Expected a ZMTP command")
+	}
+	if len(frame.body) < 1 || len(frame.body) < 1+int(frame.body[0]) {
+		return "", nil, errors.New("Invalid ZMTP command")
+	}
+	size := int(frame.body[0])
+	return string(frame.body[1 : 1+size]), frame.body[1+size:], nil
+}
+
+// An internal function that parses the properties of a READY command.
+func parseZmtpProperties(data []byte) (map[string]string, error) {
+	properties := make(map[string]string)
+	for len(data) > 0 {
+		size := int(data[0])
+		if len(data) < 1+size+4 {
+			return nil, errors.New("Invalid ZMTP property")
+		}
+		name := string(data[1 : 1+size])
+		data = data[1+size:]
+		valueSize := binary.BigEndian.Uint32(data[:4])
+		data = data[4:]
+		if uint64(len(data)) < uint64(valueSize) {
+			return nil, errors.New("Invalid ZMTP property")
+		}
+		properties[name] = string(data[:valueSize])
+		data = data[valueSize:]
+	}
+	return properties, nil
+}
+
+// An internal function that returns the reason of an ERROR command.
+func zmtpErrorReason(data []byte) string {
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "unknown"
+	}
+	return string(data[1 : 1+int(data[0])])
+}